@@ -2,8 +2,10 @@ package api
 
 import (
 	"context"
+	"crypto/subtle"
 	"fmt"
 	"net/http"
+	"timedrop/config"
 	"timedrop/helpers"
 	"timedrop/models"
 
@@ -13,21 +15,40 @@ import (
 )
 
 type handler struct {
-	handleFunc  func(http.ResponseWriter, *http.Request)
-	requireUser bool
+	handleFunc   func(http.ResponseWriter, *http.Request)
+	requireUser  bool
+	requireAdmin bool
 }
 
 func ApiHandler(h func(http.ResponseWriter, *http.Request)) http.Handler {
-	return &handler{h, false}
+	return &handler{h, false, false}
 }
 
 func ApiTokenRequired(h func(http.ResponseWriter, *http.Request)) http.Handler {
-	return &handler{h, true}
+	return &handler{h, true, false}
+}
+
+//ApiAdminRequired only lets requests through which send the configured
+//admin token in the X-Admin-Token header
+func ApiAdminRequired(h func(http.ResponseWriter, *http.Request)) http.Handler {
+	return &handler{h, false, true}
 }
 
 func (h handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	l4g.Debug("%v", r.URL.Path)
 
+	if h.requireAdmin {
+		adminToken := config.Cfg.ServiceSettings.AdminToken
+		if adminToken == "" || subtle.ConstantTimeCompare([]byte(r.Header.Get("X-Admin-Token")), []byte(adminToken)) != 1 {
+			renderer := render.New(render.Options{})
+			renderer.JSON(w, 401, helpers.GenerateErrorResponse("invalid_admin_token", r.Header))
+			return
+		}
+
+		h.handleFunc(w, r)
+		return
+	}
+
 	//if api requires user
 	if h.requireUser {
 		req := r.WithContext(context.Background())
@@ -33,24 +33,41 @@ type userTokenResponseData struct {
 	Token string      `json:"token"`
 }
 
+//registerRequestData are the only fields a client can register with
+type registerRequestData struct {
+	Username string `json:"username" valid:"required"`
+	Email    string `json:"email" valid:"email"`
+	Language string `json:"language"`
+}
+
 //Register handels /auth/register
 func (authCtrl AuthCtrl) Register(res http.ResponseWriter, req *http.Request) {
 	r := render.New(render.Options{})
 
 	decoder := json.NewDecoder(req.Body)
-	var user models.User
-	if err := decoder.Decode(&user); err != nil {
+	var data registerRequestData
+	if err := decoder.Decode(&data); err != nil {
 		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
 		return
 	}
 
-	_, err := govalidator.ValidateStruct(user)
+	_, err := govalidator.ValidateStruct(data)
 	if err != nil {
 		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
 		return
 	}
 
-	user.Score = 100
+	user := models.User{
+		Email:    data.Email,
+		Language: data.Language,
+		Score:    100,
+	}
+
+	// the same username rules as for a rename
+	if err := user.ChangeUsername(data.Username); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
 
 	if user.Email == "" {
 		user.Guest = true
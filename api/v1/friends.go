@@ -162,22 +162,12 @@ func (friendsCtrl FriendsCtrl) SendFriendRequest(res http.ResponseWriter, req *h
 		return
 	}
 
-	var friend models.Friend
-	if areFriends := friend.IsAlreadyFriendsWith(requester.ID, receiver.ID); areFriends {
-		r.JSON(res, 422, helpers.GenerateErrorResponse("already_friends", req.Header))
-		return
-	}
-
-	if receiver.ID == requester.ID {
-		r.JSON(res, 422, helpers.GenerateErrorResponse("can_not_friend_yourself", req.Header))
+	_, created, err := requester.SendFriendRequest(receiver.ID)
+	if err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
 		return
 	}
-
-	friendRequest := models.FriendRequest{
-		RequesterRefer: requester.ID,
-		ReceiverRefer:  receiver.ID,
-	}
-	if saveErr := friendRequest.Save(); saveErr != nil {
+	if !created {
 		r.JSON(res, 422, helpers.GenerateErrorResponse("friend_request_pending", req.Header))
 		return
 	}
@@ -178,6 +178,12 @@ func (gameCtrl GameCtrl) Create(res http.ResponseWriter, req *http.Request) {
 		}
 		game.OpponentRefer = uint(friendID)
 		game.FromFriendRequest = true
+
+		var block models.Block
+		if block.IsBlockedBetween(currentUser.ID, game.OpponentRefer) {
+			r.JSON(res, 403, helpers.GenerateErrorResponse(models.ErrUserBlocked.Error(), req.Header))
+			return
+		}
 	}
 
 	if game.OpponentRefer != 0 {
@@ -234,6 +240,11 @@ func (gameCtrl GameCtrl) StartGame(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if err := currentUser.ConsumeLife(); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
 	timeNow := time.Now()
 	if isCreator {
 		game.StartTimeCreator = &timeNow
@@ -249,6 +260,8 @@ func (gameCtrl GameCtrl) StartGame(res http.ResponseWriter, req *http.Request) {
 	}
 
 	if err := game.Save(); err != nil {
+		// the game never started, so the life is given back
+		currentUser.AddLives(1)
 		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
 		return
 	}
@@ -6,7 +6,7 @@ import (
 
 	"timedrop/api"
 	"timedrop/helpers"
-	"timedrop/models"
+	"timedrop/middlewares"
 
 	l4g "github.com/alecthomas/log4go"
 	"gopkg.in/asaskevich/govalidator.v4"
@@ -57,17 +57,25 @@ func (searchCtrl SearchCtrl) Search(res http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	var userRes []models.User
-	db := models.GetDatabaseSession()
-	db.Where("email LIKE ? OR username LIKE ?", "%"+searchRequest.Data+"%", "%"+searchRequest.Data+"%").Find(&userRes)
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	foundUsers, err := currentUser.SearchUsers(searchRequest.Data, 0)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
 
 	var parsedUsers []searchResult
-	for _, user := range userRes {
+	for _, foundUser := range foundUsers {
 		parsedUser := searchResult{
-			Username: user.Username,
-			ID:       user.ID,
-			Level:    user.Level,
-			Score:    user.Score,
+			Username: foundUser.User.Username,
+			ID:       foundUser.User.ID,
+			Level:    foundUser.User.Level,
+			Score:    foundUser.User.Score,
 		}
 		parsedUsers = append(parsedUsers, parsedUser)
 	}
@@ -0,0 +1,84 @@
+package v2
+
+import (
+	"fmt"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitAccount(r *mux.Router) {
+	l4g.Debug("Initializing v2 account api routes")
+	accountController := AccountCtrl{}
+	r.Handle("/account", api.ApiTokenRequired(accountController.Delete)).Methods("DELETE")
+	r.Handle("/account/export", api.ApiTokenRequired(accountController.Export)).Methods("POST")
+	r.Handle("/account/restore", api.ApiTokenRequired(accountController.Restore)).Methods("POST")
+}
+
+//AccountCtrl handels /account
+type AccountCtrl struct{}
+
+//Export returns everything stored about the current user as a JSON file
+func (accountCtrl AccountCtrl) Export(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	export, err := currentUser.ExportData()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	filename := fmt.Sprintf("timedrop-%d-%s.json", currentUser.ID, export.ExportedAt.Format("20060102"))
+	res.Header().Set("Content-Disposition", "attachment; filename=\""+filename+"\"")
+	r.JSON(res, 200, export)
+}
+
+//Delete schedules the deletion of the current user, the account can be
+//restored during the grace period
+func (accountCtrl AccountCtrl) Delete(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	accountDeletion, err := currentUser.RequestDeletion()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 202, accountDeletion)
+}
+
+//Restore cancels a scheduled deletion of the current user
+func (accountCtrl AccountCtrl) Restore(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if err := currentUser.CancelDeletion(); err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.Text(res, 204, "")
+}
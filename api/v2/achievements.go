@@ -0,0 +1,42 @@
+package v2
+
+import (
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitAchievements(r *mux.Router) {
+	l4g.Debug("Initializing v2 achievements api routes")
+	achievementsController := AchievementsCtrl{}
+	sr := r.PathPrefix("/achievements").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(achievementsController.List)).Methods("GET")
+}
+
+//AchievementsCtrl handels /achievements
+type AchievementsCtrl struct{}
+
+//List returns all achievements with the progress of the current user
+func (achievementsCtrl AchievementsCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	achievements, err := currentUser.GetAchievements()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, achievements)
+}
@@ -14,4 +14,20 @@ func InitApi() {
 	InitStatistics(r)
 	InitLifeReques(r)
 	InitUser(r)
+	InitLevels(r)
+	InitCoins(r)
+	InitPurchases(r)
+	InitReferrals(r)
+	InitAchievements(r)
+	InitSaves(r)
+	InitSync(r)
+	InitDaily(r)
+	InitGroupGames(r)
+	InitBlocks(r)
+	InitUsers(r)
+	InitFeed(r)
+	InitContacts(r)
+	InitIdentities(r)
+	InitMerge(r)
+	InitAccount(r)
 }
@@ -3,9 +3,9 @@ package v2
 import (
 	"encoding/json"
 	"errors"
+	"io"
 	"net/http"
 	"strconv"
-	"strings"
 	"timedrop/api"
 	"timedrop/helpers"
 	"timedrop/models"
@@ -23,13 +23,22 @@ func InitAuth(r *mux.Router) {
 	sr.Handle("/overwrite", api.ApiHandler(overwriteUser)).Methods("POST")
 	sr.Handle("/getUser/{userID:[0-9]+}", api.ApiHandler(getUser)).Methods("GET")
 	sr.Handle("/updateUser", api.ApiTokenRequired(updateUser)).Methods("POST")
-	sr.Handle("/changeUser", api.ApiHandler(changeUser)).Methods("POST")
+	sr.Handle("/changeUser", api.ApiTokenRequired(changeUser)).Methods("POST")
 	sr.Handle("/authToken", api.ApiHandler(authToken)).Methods("POST")
+	sr.Handle("/identity", api.ApiHandler(identityLogin)).Methods("POST")
+	sr.Handle("/facebook", api.ApiHandler(facebookLogin)).Methods("POST")
+}
+
+type createUserRequest struct {
+	InviteCode  string `json:"inviteCode"`
+	InviteToken string `json:"inviteToken"`
+	DeviceID    string `json:"deviceId"`
 }
 
 type createUserResponse struct {
-	User  models.User      `json:"user"`
-	Token models.AuthToken `json:"token"`
+	User     models.User      `json:"user"`
+	Token    models.AuthToken `json:"token"`
+	Referral *models.Referral `json:"referral,omitempty"`
 }
 
 type VerifyCode struct {
@@ -39,9 +48,17 @@ type VerifyCode struct {
 func createUser(res http.ResponseWriter, req *http.Request) {
 	r := render.New(render.Options{})
 
+	// the body is optional, older clients don't send one
+	var data createUserRequest
+	if err := json.NewDecoder(req.Body).Decode(&data); err != nil && err != io.EOF {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
 	user := models.User{
 		Username: models.GetGuestUsername(0),
 		Guest:    true,
+		DeviceID: data.DeviceID,
 	}
 
 	tokenString, err := helpers.GenerateJWTToken()
@@ -68,6 +85,16 @@ func createUser(res http.ResponseWriter, req *http.Request) {
 		Token: token,
 	}
 
+	if data.InviteCode != "" || data.InviteToken != "" {
+		referral, err := user.AttributeReferral(data.InviteCode, data.InviteToken, data.DeviceID)
+		if err != nil {
+			// an invalid invite must not stop the registration
+			l4g.Info("referral of user %d not attributed: %v", user.ID, err)
+		} else {
+			response.Referral = &referral
+		}
+	}
+
 	r.JSON(res, 200, response)
 }
 
@@ -91,6 +118,7 @@ func updateUser(res http.ResponseWriter, req *http.Request) {
 		r.JSON(res, 404, helpers.GenerateErrorResponse(err.Error(), req.Header))
 		return
 	}
+	previousUser := resultUser
 
 	var userByEmail *models.User
 	userByEmail.FindByEmail(user.Email)
@@ -132,41 +160,17 @@ func updateUser(res http.ResponseWriter, req *http.Request) {
 
 	//do ONLY save "email" if a valid verifyCode is included as well.
 	if isValidLoginCode == false {
-		if user.Username != "" {
-			var userByUsername models.User
-			userByUsername.FindByUsername(user.Username)
-			if userByUsername.ID != 0 && userByUsername.ID != currentUser.ID {
-				r.JSON(res, 200, helpers.GenerateErrorResponse("username_taken", req.Header))
-				return
-			}
-
-			if len(strings.Trim(user.Username, " ")) < 2 {
-				r.JSON(res, 200, helpers.GenerateErrorResponse("username_lenght", req.Header))
+		if user.Username != "" && user.Username != resultUser.Username {
+			if err := resultUser.ChangeUsername(user.Username); err != nil {
+				r.JSON(res, 200, helpers.GenerateErrorResponse(err.Error(), req.Header))
 				return
 			}
-
-			resultUser.Username = user.Username
 		}
 
-		if user.FacebookID != "" {
-			var userByFacebookID models.User
-			userByFacebookID.FindOtherUserByFacebookIDAndID(currentUser.ID, user.FacebookID)
-			if userByFacebookID.ID != 0 && userByFacebookID.ID != currentUser.ID {
-				//s := strconv.Itoa(int(userByFacebookID.ID))
-				response := make(map[string]interface{})
-				response["errors"] = "fb_connected"
-				response["user"] = userByFacebookID
-
-				r.JSON(res, 200, response)
-				return
-			}
-			resultUser.IsVerified = true
-
-			resultUser.FacebookID = user.FacebookID
-		}
-
-		if user.ExtraData != "" {
-			resultUser.ExtraData = user.ExtraData
+		// the Facebook ID is only set by /identities/facebook with a verified token
+		if user.FacebookID != "" && user.FacebookID != resultUser.FacebookID {
+			r.JSON(res, 403, helpers.GenerateErrorResponse("facebook_token_required", req.Header))
+			return
 		}
 
 		if user.FbImageUrl != "" {
@@ -177,68 +181,45 @@ func updateUser(res http.ResponseWriter, req *http.Request) {
 			resultUser.FbName = user.FbName
 		}
 
-		if user.IsVerified != false {
-			resultUser.IsVerified = user.IsVerified
-		}
-
-		if user.Score != 0 {
-			resultUser.Score = user.Score
-		}
-
-		if user.GamesPlayedCount != 0 {
-			resultUser.GamesPlayedCount = user.GamesPlayedCount
-		}
-
-		if user.GamesWonCount != 0 {
-			resultUser.GamesWonCount = user.GamesWonCount
-		}
-
 		if user.CurrentLevel != 0 {
 			resultUser.CurrentLevel = user.CurrentLevel
 		}
 
-		if user.Level != "" {
-			resultUser.Level = user.Level
-		}
-
-		if user.LevelRefer != 0 {
-			resultUser.LevelRefer = user.LevelRefer
-		}
-
-		if user.LevelData != "" {
-			resultUser.LevelData = user.LevelData
-		}
-
-		if user.TopLevel != "" {
-			resultUser.TopLevel = user.TopLevel
-		}
-
-		if user.TopLevelRefer != 0 {
-			resultUser.TopLevelRefer = user.TopLevelRefer
-		}
-
-		if user.AchievementsData != "" {
-			resultUser.AchievementsData = user.AchievementsData
+		if user.Avatar != 0 {
+			resultUser.Avatar = user.Avatar
 		}
+	}
 
-		if user.TreasureData != "" {
-			resultUser.TreasureData = user.TreasureData
-		}
+	if isValidLoginCode == true && user.Email != "" {
+		resultUser.MarkEmailVerified()
+	}
+	resultUser.SetContactHashes()
 
-		if user.PergamentData != "" {
-			resultUser.PergamentData = user.PergamentData
-		}
+	// coins, lives, the game counts, score and level can't be set by the
+	// client, the synced fields are only written when the client changed them
+	omitColumns := append(models.ServerOwnedUserColumns, models.GameCountUserColumns...)
+	omitColumns = append(omitColumns, models.ProgressUserColumns...)
+	db.Omit(append(omitColumns, models.SyncedUserColumns...)...).Save(&resultUser)
 
-		if user.Coins != 0 {
-			resultUser.Coins = user.Coins
-		}
+	syncedColumns := map[string]interface{}{}
+	if resultUser.CurrentLevel != previousUser.CurrentLevel {
+		syncedColumns["current_level"] = resultUser.CurrentLevel
+	}
+	if resultUser.Avatar != previousUser.Avatar {
+		syncedColumns["avatar"] = resultUser.Avatar
+	}
+	if len(syncedColumns) > 0 {
+		db.Model(&resultUser).UpdateColumns(syncedColumns)
+	}
 
-		if user.Avatar != 0 {
-			resultUser.Avatar = user.Avatar
-		}
+	if err := resultUser.UpdateSearchIndex(); err != nil {
+		l4g.Error("couldn't update search index of user %d: %v", resultUser.ID, err)
 	}
 
-	db.Save(&resultUser)
+	// let syncing devices know these fields changed
+	if err := resultUser.RecordSyncChanges(previousUser); err != nil {
+		l4g.Error("couldn't record sync changes of user %d: %v", resultUser.ID, err)
+	}
 
 	r.JSON(res, 200, resultUser)
 }
@@ -289,8 +270,13 @@ type changeUserRequest struct {
 	AuthToken  string `json:"authToken"`
 	DeleteFlag bool   `json:"deleteFlag"`
 	DeleteId   int    `json:"deleteId"`
+	VerifyCode string `json:"verifyCode"`
+	// FacebookAccessToken proves the Facebook account, the ID alone is not enough
+	FacebookAccessToken string `json:"facebookAccessToken"`
 }
 
+//changeUser returns the user the client wants to switch to, it needs the same
+//proof as a merge since the response contains the whole user
 func changeUser(res http.ResponseWriter, req *http.Request) {
 	r := render.New(render.Options{})
 
@@ -301,9 +287,8 @@ func changeUser(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var user models.User
-
 	if data.DeleteFlag {
+		var user models.User
 		err := user.FindByID(data.DeleteId)
 		if err != nil {
 			r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
@@ -311,16 +296,19 @@ func changeUser(res http.ResponseWriter, req *http.Request) {
 		}
 	}
 
-	if len(data.FacebookID) > 0 {
-		if err := user.FindByFacebookID(data.FacebookID); err != nil {
-			r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
-			return
-		}
-	} else {
-		if err := user.FindByEmail(data.Email); err != nil {
-			r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
-			return
-		}
+	proof := models.MergeProof{
+		Email:      data.Email,
+		VerifyCode: data.VerifyCode,
+	}
+	if data.FacebookAccessToken != "" {
+		proof.Provider = models.IdentityProviderFacebook
+		proof.Token = data.FacebookAccessToken
+	}
+
+	user, _, err := models.FindMergeTarget(proof, helpers.ClientIP(req), false)
+	if err != nil {
+		writeMergeError(r, res, req, err)
+		return
 	}
 
 	r.JSON(res, 200, user)
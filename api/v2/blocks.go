@@ -0,0 +1,216 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+	"gopkg.in/asaskevich/govalidator.v4"
+)
+
+func InitBlocks(r *mux.Router) {
+	l4g.Debug("Initializing v2 blocks api routes")
+	blocksController := BlocksCtrl{}
+	sr := r.PathPrefix("/blocks").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(blocksController.List)).Methods("GET")
+	sr.Handle("/", api.ApiTokenRequired(blocksController.Create)).Methods("POST")
+	sr.Handle("/{userID:[0-9]+}", api.ApiTokenRequired(blocksController.Delete)).Methods("DELETE")
+
+	reportsController := ReportsCtrl{}
+	rr := r.PathPrefix("/reports").Subrouter()
+	rr.Handle("/", api.ApiTokenRequired(reportsController.Create)).Methods("POST")
+	rr.Handle("/", api.ApiAdminRequired(reportsController.List)).Methods("GET")
+	rr.Handle("/{reportID:[0-9]+}/resolve", api.ApiAdminRequired(reportsController.Resolve)).Methods("POST")
+}
+
+//BlocksCtrl handels /blocks
+type BlocksCtrl struct{}
+
+//ReportsCtrl handels /reports
+type ReportsCtrl struct{}
+
+//limit rows for the moderation queue
+const reportsLimit int = 100
+
+type blockRequestData struct {
+	UserID uint `json:"userId" valid:"required"`
+}
+
+type reportRequestData struct {
+	UserID  uint   `json:"userId" valid:"required"`
+	GameID  uint   `json:"gameId"`
+	Reason  string `json:"reason" valid:"required"`
+	Comment string `json:"comment" valid:"length(0|1000)"`
+}
+
+type resolveReportRequestData struct {
+	Resolution string `json:"resolution" valid:"required"`
+}
+
+//List returns the users blocked by the current user
+func (blocksCtrl BlocksCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var block models.Block
+	blocks, err := block.FindByBlocker(currentUser.ID)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	parsedBlocks := []models.Block{}
+	for _, block := range blocks {
+		block.Blocked.FindByID(block.BlockedRefer)
+		block.Blocked.Email = ""
+		parsedBlocks = append(parsedBlocks, block)
+	}
+
+	r.JSON(res, 200, parsedBlocks)
+}
+
+//Create blocks a user
+func (blocksCtrl BlocksCtrl) Create(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var blockRequest blockRequestData
+	if err := decoder.Decode(&blockRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(blockRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	block, err := currentUser.BlockUser(blockRequest.UserID)
+	if err != nil {
+		if err == models.ErrCanNotBlockYourself {
+			r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+			return
+		}
+		r.JSON(res, 404, helpers.GenerateErrorResponse("user_not_found", req.Header))
+		return
+	}
+
+	block.Blocked.Email = ""
+	r.JSON(res, 201, block)
+}
+
+//Delete lifts the block of a user
+func (blocksCtrl BlocksCtrl) Delete(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if err := currentUser.UnblockUser(mux.Vars(req)["userID"]); err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.Text(res, 204, "")
+}
+
+//Create files a report about a user
+func (reportsCtrl ReportsCtrl) Create(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var reportRequest reportRequestData
+	if err := decoder.Decode(&reportRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(reportRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	report, err := currentUser.ReportUser(reportRequest.UserID, reportRequest.GameID, reportRequest.Reason, reportRequest.Comment)
+	if err != nil {
+		switch err {
+		case models.ErrReportReasonUnknown, models.ErrReportDuplicate:
+			r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		default:
+			r.JSON(res, 404, helpers.GenerateErrorResponse("user_not_found", req.Header))
+		}
+		return
+	}
+
+	r.JSON(res, 201, report)
+}
+
+//List returns the reports with the given state, open ones by default
+func (reportsCtrl ReportsCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	state := req.FormValue("state")
+	if state == "" {
+		state = models.ReportStateOpen
+	}
+
+	var report models.Report
+	reports, err := report.FindByState(state, reportsLimit)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, reports)
+}
+
+//Resolve closes a report
+func (reportsCtrl ReportsCtrl) Resolve(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var resolveReportRequest resolveReportRequestData
+	if err := decoder.Decode(&resolveReportRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(resolveReportRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var report models.Report
+	if err := report.Resolve(mux.Vars(req)["reportID"], resolveReportRequest.Resolution); err != nil {
+		r.JSON(res, 404, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, report)
+}
@@ -0,0 +1,143 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+	"gopkg.in/asaskevich/govalidator.v4"
+)
+
+func InitCoins(r *mux.Router) {
+	l4g.Debug("Initializing v2 coins api routes")
+	coinsController := CoinsCtrl{}
+	sr := r.PathPrefix("/coins").Subrouter()
+	sr.Handle("/transactions", api.ApiTokenRequired(coinsController.ListTransactions)).Methods("GET")
+	sr.Handle("/spend", api.ApiTokenRequired(coinsController.Spend)).Methods("POST")
+	sr.Handle("/grant", api.ApiAdminRequired(coinsController.Grant)).Methods("POST")
+}
+
+//CoinsCtrl handels /coins
+type CoinsCtrl struct{}
+
+//limit rows for the transaction list
+const coinTransactionsLimit int = 100
+
+type coinsResponse struct {
+	Coins       int                    `json:"coins"`
+	Transaction models.CoinTransaction `json:"transaction"`
+}
+
+type spendCoinsRequestData struct {
+	Amount         int    `json:"amount" valid:"required"`
+	Reason         string `json:"reason" valid:"required"`
+	IdempotencyKey string `json:"idempotencyKey" valid:"required"`
+}
+
+type grantCoinsRequestData struct {
+	UserID         uint   `json:"userId" valid:"required"`
+	Amount         int    `json:"amount" valid:"required"`
+	Reason         string `json:"reason"`
+	IdempotencyKey string `json:"idempotencyKey" valid:"required"`
+}
+
+//ListTransactions returns the latest coin transactions of the current user
+func (coinsCtrl CoinsCtrl) ListTransactions(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var coinTransaction models.CoinTransaction
+	coinTransactions, err := coinTransaction.FindByUserID(currentUser.ID, coinTransactionsLimit)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, coinTransactions)
+}
+
+//Spend books coins spent by the current user
+func (coinsCtrl CoinsCtrl) Spend(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var spendCoinsRequest spendCoinsRequestData
+	if err := decoder.Decode(&spendCoinsRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(spendCoinsRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	reason := models.CoinReasonSpend + ":" + spendCoinsRequest.Reason
+	coinTransaction, err := currentUser.SpendCoins(spendCoinsRequest.Amount, reason, spendCoinsRequest.IdempotencyKey)
+	if err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, coinsResponse{
+		Coins:       currentUser.Coins,
+		Transaction: coinTransaction,
+	})
+}
+
+//Grant books coins for any user, used by support
+func (coinsCtrl CoinsCtrl) Grant(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var grantCoinsRequest grantCoinsRequestData
+	if err := decoder.Decode(&grantCoinsRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(grantCoinsRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var user models.User
+	if err := user.FindByID(grantCoinsRequest.UserID); err != nil {
+		r.JSON(res, 404, helpers.GenerateErrorResponse("user_not_found", req.Header))
+		return
+	}
+
+	reason := models.CoinReasonSupport
+	if grantCoinsRequest.Reason != "" {
+		reason = reason + ":" + grantCoinsRequest.Reason
+	}
+
+	coinTransaction, err := user.GrantCoins(grantCoinsRequest.Amount, reason, grantCoinsRequest.IdempotencyKey)
+	if err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, coinsResponse{
+		Coins:       user.Coins,
+		Transaction: coinTransaction,
+	})
+}
@@ -0,0 +1,82 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/config"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitContacts(r *mux.Router) {
+	l4g.Debug("Initializing v2 contacts api routes")
+	contactsController := ContactsCtrl{}
+	sr := r.PathPrefix("/contacts").Subrouter()
+	sr.Handle("/salt", api.ApiTokenRequired(contactsController.Salt)).Methods("GET")
+	sr.Handle("/import", api.ApiTokenRequired(contactsController.Import)).Methods("POST")
+}
+
+//ContactsCtrl handels /contacts
+type ContactsCtrl struct{}
+
+type contactSaltResponse struct {
+	Salt string `json:"salt"`
+	// Format describes how the clients build the hashes
+	Format string `json:"format"`
+}
+
+type importContactsRequestData struct {
+	// Hashes of the normalized emails, see models.ContactHash
+	Hashes []string `json:"hashes"`
+}
+
+//Salt returns the salt the clients hash their contacts with
+func (contactsCtrl ContactsCtrl) Salt(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	r.JSON(res, 200, contactSaltResponse{
+		Salt:   config.Cfg.ContactSettings.HashSalt,
+		Format: "hex(sha256(salt + \":\" + value)), emails lowercased and trimmed",
+	})
+}
+
+//Import matches the hashed address book of the current user
+func (contactsCtrl ContactsCtrl) Import(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var importContactsRequest importContactsRequestData
+	if err := decoder.Decode(&importContactsRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	matches, err := currentUser.ImportContacts(importContactsRequest.Hashes)
+	if err != nil {
+		if err == models.ErrTooManyContacts {
+			r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+			return
+		}
+		if err == models.ErrContactImportQuota {
+			r.JSON(res, 429, helpers.GenerateErrorResponse(err.Error(), req.Header))
+			return
+		}
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, matches)
+}
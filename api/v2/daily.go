@@ -0,0 +1,170 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"timedrop/api"
+	"timedrop/config"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+	"gopkg.in/asaskevich/govalidator.v4"
+)
+
+func InitDaily(r *mux.Router) {
+	l4g.Debug("Initializing v2 daily api routes")
+	dailyController := DailyCtrl{}
+	sr := r.PathPrefix("/daily").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(dailyController.Get)).Methods("GET")
+	sr.Handle("/start", api.ApiTokenRequired(dailyController.Start)).Methods("POST")
+	sr.Handle("/result", api.ApiTokenRequired(dailyController.Result)).Methods("POST")
+	sr.Handle("/leaderboard", api.ApiTokenRequired(dailyController.Leaderboard)).Methods("GET")
+}
+
+//DailyCtrl handels /daily
+type DailyCtrl struct{}
+
+type dailyResponse struct {
+	Challenge models.DailyChallenge `json:"challenge"`
+	Attempt   *models.DailyAttempt  `json:"attempt"`
+	Streak    int                   `json:"streak"`
+}
+
+type dailyResultRequestData struct {
+	Data int `json:"data" valid:"required"`
+}
+
+type dailyResultResponse struct {
+	Attempt models.DailyAttempt `json:"attempt"`
+	Rank    int                 `json:"rank"`
+	Coins   int                 `json:"coins"`
+}
+
+type dailyLeaderboardResponse struct {
+	Date     string                `json:"date"`
+	Attempts []models.DailyAttempt `json:"attempts"`
+	Rank     int                   `json:"rank,omitempty"`
+}
+
+//Get returns today's challenge with the attempt and streak of the current user
+func (dailyCtrl DailyCtrl) Get(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	now := time.Now()
+	response := dailyResponse{
+		Challenge: models.GetDailyChallenge(now),
+		Streak:    currentUser.DailyStreak(now),
+	}
+	if attempt, err := currentUser.FindDailyAttempt(response.Challenge.Date); err == nil {
+		response.Attempt = &attempt
+	}
+
+	r.JSON(res, 200, response)
+}
+
+//Start uses the one attempt of today
+func (dailyCtrl DailyCtrl) Start(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	now := time.Now()
+	attempt, err := currentUser.StartDailyChallenge(now)
+	if err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, dailyResponse{
+		Challenge: models.GetDailyChallenge(now),
+		Attempt:   &attempt,
+		Streak:    currentUser.DailyStreak(now),
+	})
+}
+
+//Result saves the score of the started attempt and rewards the user
+func (dailyCtrl DailyCtrl) Result(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var dailyResultRequest dailyResultRequestData
+	if err := decoder.Decode(&dailyResultRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(dailyResultRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	attempt, err := currentUser.CompleteDailyChallenge(dailyResultRequest.Data, time.Now())
+	if err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, dailyResultResponse{
+		Attempt: attempt,
+		Rank:    attempt.DailyRank(),
+		Coins:   currentUser.Coins,
+	})
+}
+
+//Leaderboard returns the best results of a day, today if no date is given
+func (dailyCtrl DailyCtrl) Leaderboard(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	date := req.FormValue("date")
+	if date == "" {
+		date = models.DailyDate(time.Now())
+	} else if _, err := time.Parse("2006-01-02", date); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse("invalid_date", req.Header))
+		return
+	}
+
+	var attempt models.DailyAttempt
+	attempts, err := attempt.FindDailyLeaderboard(date, config.Cfg.DailySettings.LeaderboardSize)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	response := dailyLeaderboardResponse{
+		Date:     date,
+		Attempts: attempts,
+	}
+	if ownAttempt, err := currentUser.FindDailyAttempt(date); err == nil && ownAttempt.State == models.DailyAttemptStateCompleted {
+		response.Rank = ownAttempt.DailyRank()
+	}
+
+	r.JSON(res, 200, response)
+}
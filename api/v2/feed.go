@@ -0,0 +1,53 @@
+package v2
+
+import (
+	"net/http"
+	"strconv"
+
+	"timedrop/api"
+	"timedrop/config"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitFeed(r *mux.Router) {
+	l4g.Debug("Initializing v2 feed api routes")
+	feedController := FeedCtrl{}
+	sr := r.PathPrefix("/feed").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(feedController.List)).Methods("GET")
+}
+
+//FeedCtrl handels /feed
+type FeedCtrl struct{}
+
+//List returns the activity of the friends of the current user, older pages
+//are requested with ?before=<id of the last item>
+func (feedCtrl FeedCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var beforeID uint64
+	if before := req.FormValue("before"); before != "" {
+		if beforeID, err = strconv.ParseUint(before, 10, 64); err != nil {
+			r.JSON(res, 422, helpers.GenerateErrorResponse("invalid_cursor", req.Header))
+			return
+		}
+	}
+
+	feedItems, err := currentUser.FindFeed(uint(beforeID), config.Cfg.FeedSettings.PageSize)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, feedItems)
+}
@@ -22,9 +22,13 @@ func InitFriend(r *mux.Router) {
 	sr.Handle("/", api.ApiTokenRequired(friendsController.List)).Methods("GET")
 	sr.Handle("/", api.ApiTokenRequired(friendsController.AddFriend)).Methods("POST")
 	sr.Handle("/{friendID:[0-9]+}", api.ApiTokenRequired(friendsController.RemoveFriend)).Methods("DELETE")
+	sr.Handle("/suggestions", api.ApiTokenRequired(friendsController.Suggestions)).Methods("GET")
 	sr.Handle("/request", api.ApiTokenRequired(friendsController.SendFriendRequest)).Methods("POST")
 	sr.Handle("/request", api.ApiTokenRequired(friendsController.ListFriendRequest)).Methods("GET")
-	sr.Handle("/request/{friendID:[0-9]+}", api.ApiTokenRequired(friendsController.DeclineFriendRequest)).Methods("DELETE")
+	sr.Handle("/request/{friendRequestID:[0-9]+}", api.ApiTokenRequired(friendsController.DeleteFriendRequest)).Methods("DELETE")
+	sr.Handle("/request/{friendRequestID:[0-9]+}/accept", api.ApiTokenRequired(friendsController.AcceptFriendRequest)).Methods("POST")
+	sr.Handle("/request/{friendRequestID:[0-9]+}/decline", api.ApiTokenRequired(friendsController.DeclineFriendRequest)).Methods("POST")
+	sr.Handle("/request/{friendRequestID:[0-9]+}/cancel", api.ApiTokenRequired(friendsController.CancelFriendRequest)).Methods("POST")
 	sr.Handle("/updateFriends", api.ApiTokenRequired(friendsController.UpdateFriends)).Methods("POST")
 	sr2 := r.PathPrefix("/").Subrouter()
 	sr2.Handle("/updateFriends", api.ApiTokenRequired(friendsController.UpdateFacebookFriends)).Methods("POST")
@@ -128,7 +132,7 @@ func (friendsCtrl FriendsCtrl) ListFriendRequest(res http.ResponseWriter, req *h
 	var parsedPendingFriendRequests []friendRequestPendingReponse
 	for _, fReq := range pendingFriendRequests {
 		var tmpUser models.User
-		tmpUser.FindByID(fReq.RequesterRefer)
+		tmpUser.FindByID(fReq.ReceiverRefer)
 
 		if tmpUser.ID != 0 {
 			tmpUser.Email = ""
@@ -183,23 +187,19 @@ func (friendsCtrl FriendsCtrl) SendFriendRequest(res http.ResponseWriter, req *h
 		return
 	}
 
-	var friend models.Friend
-	if areFriends := friend.IsAlreadyFriendsWith(requester.ID, receiver.ID); areFriends {
-		r.JSON(res, 422, helpers.GenerateErrorResponse("already_friends", req.Header))
-		return
-	}
-
-	if receiver.ID == requester.ID {
-		r.JSON(res, 422, helpers.GenerateErrorResponse("can_not_friend_yourself", req.Header))
+	friendRequest, created, err := requester.SendFriendRequest(receiver.ID)
+	if err != nil {
+		if err == models.ErrUserBlocked {
+			r.JSON(res, 403, helpers.GenerateErrorResponse(err.Error(), req.Header))
+			return
+		}
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
 		return
 	}
 
-	friendRequest := models.FriendRequest{
-		RequesterRefer: requester.ID,
-		ReceiverRefer:  receiver.ID,
-	}
-	if saveErr := friendRequest.Save(); saveErr != nil {
-		r.JSON(res, 422, helpers.GenerateErrorResponse("friend_request_pending", req.Header))
+	// repeated requests and accepted requests of the receiver don't notify again
+	if !created {
+		r.JSON(res, 200, friendRequest)
 		return
 	}
 
@@ -210,12 +210,76 @@ func (friendsCtrl FriendsCtrl) SendFriendRequest(res http.ResponseWriter, req *h
 	return
 }
 
-//DeclineFriendRequest handels /friends/request/{friendRequestID}
+//DeleteFriendRequest handels /friends/request/{friendRequestID} (DELETE),
+//the receiver declines the request and the requester cancels it
+func (friendsCtrl FriendsCtrl) DeleteFriendRequest(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	friendRequestID := mux.Vars(req)["friendRequestID"]
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var friendRequest models.FriendRequest
+	err = friendRequest.FindAndDecclineFriendRequest(friendRequestID, currentUser.ID)
+	if err == models.ErrFriendRequestNotReceiver {
+		err = friendRequest.FindAndCancelFriendRequest(friendRequestID, currentUser.ID)
+	}
+	if err != nil {
+		writeFriendRequestError(r, res, req, err)
+		return
+	}
+
+	r.Text(res, 204, "")
+}
+
+//AcceptFriendRequest handels /friends/request/{friendRequestID}/accept
+func (friendsCtrl FriendsCtrl) AcceptFriendRequest(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	receiver, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var friendRequest models.FriendRequest
+	if isAdded := friendRequest.FindAndAcceptFriendRequest(mux.Vars(req)["friendRequestID"], receiver.ID); !isAdded {
+		r.JSON(res, 422, helpers.GenerateErrorResponse("invalid_friend_request", req.Header))
+		return
+	}
+
+	var friend models.Friend
+	friends, _ := friend.FindByUserID(receiver.ID)
+
+	r.JSON(res, 201, friends)
+}
+
+//DeclineFriendRequest handels /friends/request/{friendRequestID}/decline
 func (friendsCtrl FriendsCtrl) DeclineFriendRequest(res http.ResponseWriter, req *http.Request) {
 	r := render.New(render.Options{})
 
-	vars := mux.Vars(req)
-	friendRequestID := vars["friendRequestID"]
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var friendRequest models.FriendRequest
+	if err := friendRequest.FindAndDecclineFriendRequest(mux.Vars(req)["friendRequestID"], currentUser.ID); err != nil {
+		writeFriendRequestError(r, res, req, err)
+		return
+	}
+
+	r.Text(res, 204, "")
+}
+
+//CancelFriendRequest handels /friends/request/{friendRequestID}/cancel
+func (friendsCtrl FriendsCtrl) CancelFriendRequest(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
 
 	currentUser, err := middlewares.GetUserFromContext(res, req)
 	if err != nil {
@@ -224,10 +288,26 @@ func (friendsCtrl FriendsCtrl) DeclineFriendRequest(res http.ResponseWriter, req
 	}
 
 	var friendRequest models.FriendRequest
-	friendRequest.FindAndDecclineFriendRequest(friendRequestID, currentUser.ID)
+	if err := friendRequest.FindAndCancelFriendRequest(mux.Vars(req)["friendRequestID"], currentUser.ID); err != nil {
+		writeFriendRequestError(r, res, req, err)
+		return
+	}
 
-	r.Text(res, 201, "")
-	return
+	r.Text(res, 204, "")
+}
+
+//writeFriendRequestError maps the friend request errors to status codes
+func writeFriendRequestError(r *render.Render, res http.ResponseWriter, req *http.Request, err error) {
+	switch err {
+	case models.ErrFriendRequestNotFound:
+		r.JSON(res, 404, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	case models.ErrFriendRequestNotReceiver, models.ErrFriendRequestNotRequester:
+		r.JSON(res, 403, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	case models.ErrFriendRequestNotPending:
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	default:
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	}
 }
 
 type addFriendRequestData struct {
@@ -289,6 +369,25 @@ func (friendsCtrl FriendsCtrl) RemoveFriend(res http.ResponseWriter, req *http.R
 	return
 }
 
+//Suggestions handels /friends/suggestions
+func (friendsCtrl FriendsCtrl) Suggestions(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	user, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	suggestions, err := user.GetFriendSuggestions()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, suggestions)
+}
+
 type updateFriendRequest struct {
 	Friends []uint `json:"friends"`
 }
@@ -349,7 +448,7 @@ func (friendsCtrl FriendsCtrl) UpdateFacebookFriends(res http.ResponseWriter, re
 	var users models.User
 	userIds := users.FindAllByFacebookIDs(data.Friends, user.ID)
 
-	//update user friends
+	//update user friends, they don't need to be suggested
 	user.AddUserFriends(userIds, user.ID)
 
 	//show friends
@@ -31,6 +31,7 @@ func InitGames(r *mux.Router) {
 	sr.Handle("/", api.ApiTokenRequired(gamesController.Create)).Methods("POST")
 	sr.Handle("/{gameID:[0-9]+}/start", api.ApiTokenRequired(gamesController.StartGame)).Methods("POST")
 	sr.Handle("/{gameID:[0-9]+}/result", api.ApiTokenRequired(gamesController.Result)).Methods("POST")
+	sr.Handle("/{gameID:[0-9]+}/replay", api.ApiTokenRequired(gamesController.Replay)).Methods("GET")
 }
 
 //GameCtrl is the controller for /games
@@ -178,6 +179,12 @@ func (gameCtrl GameCtrl) Create(res http.ResponseWriter, req *http.Request) {
 		}
 		game.OpponentRefer = uint(friendID)
 		game.FromFriendRequest = true
+
+		var block models.Block
+		if block.IsBlockedBetween(currentUser.ID, game.OpponentRefer) {
+			r.JSON(res, 403, helpers.GenerateErrorResponse(models.ErrUserBlocked.Error(), req.Header))
+			return
+		}
 	}
 
 	if game.OpponentRefer != 0 {
@@ -234,6 +241,11 @@ func (gameCtrl GameCtrl) StartGame(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if err := currentUser.ConsumeLife(); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
 	timeNow := time.Now()
 	if isCreator {
 		game.StartTimeCreator = &timeNow
@@ -249,6 +261,8 @@ func (gameCtrl GameCtrl) StartGame(res http.ResponseWriter, req *http.Request) {
 	}
 
 	if err := game.Save(); err != nil {
+		// the game never started, so the life is given back
+		currentUser.AddLives(1)
 		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
 		return
 	}
@@ -259,6 +273,8 @@ func (gameCtrl GameCtrl) StartGame(res http.ResponseWriter, req *http.Request) {
 
 type resultGameRequestData struct {
 	Data int `json:"data" valid:"required"`
+	// Replay is optional, see models.GameReplay
+	Replay string `json:"replay"`
 }
 
 //Result saves the result
@@ -299,6 +315,13 @@ func (gameCtrl GameCtrl) Result(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	if resultGameRequest.Replay != "" {
+		if err := models.ValidateReplay(resultGameRequest.Replay); err != nil {
+			r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+			return
+		}
+	}
+
 	isCreator := game.CreatorRefer == currentUser.ID
 	if isCreator {
 		if game.StateCreator != models.GameStateStarted {
@@ -335,6 +358,13 @@ func (gameCtrl GameCtrl) Result(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
+	// the result counts even if the replay couldn't be stored
+	if resultGameRequest.Replay != "" {
+		if err := game.SaveReplay(currentUser.ID, resultGameRequest.Replay); err != nil {
+			l4g.Error("couldn't save replay of user %d for game %d: %v", currentUser.ID, game.ID, err)
+		}
+	}
+
 	if (game.StateCreator == models.GameStateCompleted) && (game.StateOpponent == models.GameStateCompleted) {
 		if err := game.Complete(); err != nil {
 			r.JSON(res, 401, helpers.GenerateErrorResponse(err.Error(), req.Header))
@@ -345,3 +375,45 @@ func (gameCtrl GameCtrl) Result(res http.ResponseWriter, req *http.Request) {
 	r.JSON(res, 200, game)
 	return
 }
+
+//Replay returns the replay of the opponent once the current user finished
+func (gameCtrl GameCtrl) Replay(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	vars := mux.Vars(req)
+	gameID := vars["gameID"]
+
+	var game models.Game
+	if err := game.FindByID(gameID); err != nil {
+		if err == gorm.ErrRecordNotFound {
+			r.JSON(res, 404, helpers.GenerateErrorResponse("game_not_found", req.Header))
+			return
+		}
+
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if game.CreatorRefer != currentUser.ID && game.OpponentRefer != currentUser.ID {
+		r.JSON(res, 422, helpers.GenerateErrorResponse("game_not_related", req.Header))
+		return
+	}
+
+	gameReplay, err := game.FindOpponentReplay(currentUser.ID)
+	if err != nil {
+		status := 404
+		if err == models.ErrReplayNotFinished {
+			status = 403
+		}
+		r.JSON(res, status, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, gameReplay)
+}
@@ -0,0 +1,227 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+	"gopkg.in/asaskevich/govalidator.v4"
+)
+
+func InitGroupGames(r *mux.Router) {
+	l4g.Debug("Initializing v2 group games api routes")
+	groupGamesController := GroupGamesCtrl{}
+	sr := r.PathPrefix("/groupgames").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(groupGamesController.List)).Methods("GET")
+	sr.Handle("/", api.ApiTokenRequired(groupGamesController.Create)).Methods("POST")
+	sr.Handle("/{groupGameID:[0-9]+}", api.ApiTokenRequired(groupGamesController.Get)).Methods("GET")
+	sr.Handle("/{groupGameID:[0-9]+}/join", api.ApiTokenRequired(groupGamesController.Join)).Methods("POST")
+	sr.Handle("/{groupGameID:[0-9]+}/start", api.ApiTokenRequired(groupGamesController.Start)).Methods("POST")
+	sr.Handle("/{groupGameID:[0-9]+}/result", api.ApiTokenRequired(groupGamesController.Result)).Methods("POST")
+}
+
+//GroupGamesCtrl handels /groupgames
+type GroupGamesCtrl struct{}
+
+type createGroupGameRequestData struct {
+	FriendIDs []uint `json:"friendIds" valid:"required"`
+}
+
+type groupGameResultRequestData struct {
+	Data int `json:"data" valid:"required"`
+}
+
+//renderGroupGameError maps the group game errors to their status codes
+func renderGroupGameError(res http.ResponseWriter, req *http.Request, err error) {
+	r := render.New(render.Options{})
+
+	status := 422
+	switch err {
+	case models.ErrGroupGameNotFound:
+		status = 404
+	case models.ErrGroupGameNotFriends, models.ErrUserBlocked:
+		status = 403
+	}
+	r.JSON(res, status, helpers.GenerateErrorResponse(err.Error(), req.Header))
+}
+
+//List returns the group games of the current user
+func (groupGamesCtrl GroupGamesCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	var groupGame models.GroupGame
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	groupGames, err := groupGame.FindByUserID(currentUser.ID, historyLimit)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, groupGames)
+}
+
+//Create creates a group game and invites the given friends
+func (groupGamesCtrl GroupGamesCtrl) Create(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var createGroupGameRequest createGroupGameRequestData
+	if err := decoder.Decode(&createGroupGameRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(createGroupGameRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	groupGame, err := currentUser.CreateGroupGame(createGroupGameRequest.FriendIDs)
+	if err != nil {
+		renderGroupGameError(res, req, err)
+		return
+	}
+
+	r.JSON(res, 201, groupGame)
+}
+
+//findGroupGame loads the group game of the route
+func findGroupGame(req *http.Request) (models.GroupGame, error) {
+	var groupGame models.GroupGame
+	err := groupGame.FindByID(mux.Vars(req)["groupGameID"])
+	return groupGame, err
+}
+
+//Get returns a group game with its ranked participants
+func (groupGamesCtrl GroupGamesCtrl) Get(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	groupGame, err := findGroupGame(req)
+	if err != nil {
+		renderGroupGameError(res, req, err)
+		return
+	}
+
+	// friends of the creator may look at it before joining
+	var friend models.Friend
+	isParticipant := false
+	for _, participant := range groupGame.Participants {
+		isParticipant = isParticipant || participant.UserRefer == currentUser.ID
+	}
+	if !isParticipant && !friend.IsAlreadyFriendsWith(currentUser.ID, groupGame.CreatorRefer) {
+		renderGroupGameError(res, req, models.ErrGroupGameNotFound)
+		return
+	}
+
+	r.JSON(res, 200, groupGame)
+}
+
+//Join lets the current user take part in the group game
+func (groupGamesCtrl GroupGamesCtrl) Join(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	groupGame, err := findGroupGame(req)
+	if err != nil {
+		renderGroupGameError(res, req, err)
+		return
+	}
+
+	participant, err := groupGame.Join(&currentUser)
+	if err != nil {
+		renderGroupGameError(res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, participant)
+}
+
+//Start starts the play of the current user
+func (groupGamesCtrl GroupGamesCtrl) Start(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	groupGame, err := findGroupGame(req)
+	if err != nil {
+		renderGroupGameError(res, req, err)
+		return
+	}
+
+	participant, err := groupGame.Start(&currentUser)
+	if err != nil {
+		renderGroupGameError(res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, participant)
+}
+
+//Result saves the score of the current user
+func (groupGamesCtrl GroupGamesCtrl) Result(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var groupGameResultRequest groupGameResultRequestData
+	if err := decoder.Decode(&groupGameResultRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(groupGameResultRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	groupGame, err := findGroupGame(req)
+	if err != nil {
+		renderGroupGameError(res, req, err)
+		return
+	}
+
+	if _, err := groupGame.SaveResult(&currentUser, groupGameResultRequest.Data); err != nil {
+		renderGroupGameError(res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, groupGame)
+}
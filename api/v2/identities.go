@@ -0,0 +1,228 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitIdentities(r *mux.Router) {
+	l4g.Debug("Initializing v2 identities api routes")
+	identitiesController := IdentitiesCtrl{}
+	sr := r.PathPrefix("/identities").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(identitiesController.List)).Methods("GET")
+	sr.Handle("/", api.ApiTokenRequired(identitiesController.Link)).Methods("POST")
+	sr.Handle("/facebook", api.ApiTokenRequired(identitiesController.LinkFacebook)).Methods("POST")
+	sr.Handle("/{provider}", api.ApiTokenRequired(identitiesController.Unlink)).Methods("DELETE")
+}
+
+//IdentitiesCtrl handels /identities
+type IdentitiesCtrl struct{}
+
+type identityRequestData struct {
+	// Provider is apple, google or facebook
+	Provider string `json:"provider"`
+	// Token is the ID token the client got from the provider
+	Token string `json:"token"`
+}
+
+type facebookRequestData struct {
+	// AccessToken of the classic Facebook login or an ID token of Limited Login
+	AccessToken string `json:"accessToken"`
+}
+
+type identityLoginResponse struct {
+	User    models.User      `json:"user"`
+	Token   models.AuthToken `json:"token"`
+	Created bool             `json:"created"`
+}
+
+//writeIdentityError maps the identity errors to status codes
+func writeIdentityError(r *render.Render, res http.ResponseWriter, req *http.Request, err error) {
+	switch err {
+	case models.ErrInvalidIdentityToken:
+		r.JSON(res, 401, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	case models.ErrIdentityUnavailable:
+		r.JSON(res, 503, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	case models.ErrIdentityNotLinked:
+		r.JSON(res, 404, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	case models.ErrIdentityLinkedToOtherUser, models.ErrIdentityLinkRequired:
+		r.JSON(res, 409, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	case models.ErrIdentityProviderUnknown, models.ErrIdentityProviderAlreadyLinked, models.ErrCanNotUnlinkLastLogin:
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	default:
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	}
+}
+
+//identityLogin signs in with the ID token of a provider, unknown identities
+//get a new user
+func identityLogin(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var data identityRequestData
+	if err := decoder.Decode(&data); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	loginWithIdentity(r, res, req, data.Provider, data.Token)
+}
+
+//facebookLogin signs in with a Facebook token, the Facebook ID is taken from
+//Facebook
+func facebookLogin(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var data facebookRequestData
+	if err := decoder.Decode(&data); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	loginWithIdentity(r, res, req, models.IdentityProviderFacebook, data.AccessToken)
+}
+
+//loginWithIdentity verifies the token and answers with the user of the
+//identity and a new auth token
+func loginWithIdentity(r *render.Render, res http.ResponseWriter, req *http.Request, provider, identityToken string) {
+	identity, err := models.VerifyIdentity(provider, identityToken)
+	if err != nil {
+		writeIdentityError(r, res, req, err)
+		return
+	}
+
+	user, created, err := models.LoginWithIdentity(identity)
+	if err != nil {
+		writeIdentityError(r, res, req, err)
+		return
+	}
+
+	tokenString, err := helpers.GenerateJWTToken()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	token := models.AuthToken{
+		Token:     tokenString,
+		UserRefer: user.ID,
+	}
+
+	user.AppendAuthToken(token)
+	if err := user.Save(); err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, identityLoginResponse{
+		User:    user,
+		Token:   token,
+		Created: created,
+	})
+}
+
+//List the identities linked to the current user
+func (identitiesCtrl IdentitiesCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	identities, err := currentUser.FindIdentities()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, identities)
+}
+
+//Link an identity of a provider to the current user
+func (identitiesCtrl IdentitiesCtrl) Link(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var data identityRequestData
+	if err := decoder.Decode(&data); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	linkIdentity(r, res, req, currentUser, data.Provider, data.Token)
+}
+
+//LinkFacebook links the Facebook account of a token to the current user
+func (identitiesCtrl IdentitiesCtrl) LinkFacebook(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var data facebookRequestData
+	if err := decoder.Decode(&data); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	linkIdentity(r, res, req, currentUser, models.IdentityProviderFacebook, data.AccessToken)
+}
+
+//linkIdentity verifies the token and links the identity to the user
+func linkIdentity(r *render.Render, res http.ResponseWriter, req *http.Request, user models.User, provider, identityToken string) {
+	identity, err := models.VerifyIdentity(provider, identityToken)
+	if err != nil {
+		writeIdentityError(r, res, req, err)
+		return
+	}
+
+	userIdentity, err := user.LinkIdentity(identity)
+	if err != nil {
+		writeIdentityError(r, res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, userIdentity)
+}
+
+//Unlink a provider from the current user
+func (identitiesCtrl IdentitiesCtrl) Unlink(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+	vars := mux.Vars(req)
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if err := currentUser.UnlinkIdentity(vars["provider"]); err != nil {
+		writeIdentityError(r, res, req, err)
+		return
+	}
+
+	r.Text(res, 204, "")
+}
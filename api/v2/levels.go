@@ -0,0 +1,37 @@
+package v2
+
+import (
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitLevels(r *mux.Router) {
+	l4g.Debug("Initializing v2 levels api routes")
+	levelsController := LevelsCtrl{}
+	sr := r.PathPrefix("/levels").Subrouter()
+	sr.Handle("/", api.ApiHandler(levelsController.List)).Methods("GET")
+}
+
+//LevelsCtrl handels /levels
+type LevelsCtrl struct{}
+
+//List returns all levels with their score thresholds
+func (levelsCtrl LevelsCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	var level models.Level
+	levels, err := level.FindAll()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, levels)
+}
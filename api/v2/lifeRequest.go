@@ -57,9 +57,17 @@ func (LifeRequestCtrl LifeRequestCtrl) create(res http.ResponseWriter, req *http
 	}
 
 	var lifeRequest models.LifeRequest
-	lifeRequest.CreateLifeRecords(data.Receivers, user.ID)
+	created, rejected := lifeRequest.CreateLifeRecords(data.Receivers, user.ID)
 
-	r.JSON(res, 200, map[string]string{})
+	if len(created) == 0 && len(rejected) > 0 {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(firstRejection(data.Receivers, rejected), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, map[string]interface{}{
+		"created":  created,
+		"rejected": rejectionCodes(rejected),
+	})
 	return
 }
 
@@ -110,12 +118,40 @@ func (LifeRequestCtrl LifeRequestCtrl) giveLife(res http.ResponseWriter, req *ht
 	}
 
 	var lifeRequest models.LifeRequest
-	lifeRequest.GiveLife(data.Requesters, user.ID)
+	given, rejected := lifeRequest.GiveLife(data.Requesters, user.ID)
 
-	r.JSON(res, 200, map[string]string{})
+	if len(given) == 0 && len(rejected) > 0 {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(firstRejection(data.Requesters, rejected), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, map[string]interface{}{
+		"given":    given,
+		"rejected": rejectionCodes(rejected),
+	})
 	return
 }
 
+//rejectionCodes maps the rejected user ids to their error code
+func rejectionCodes(rejected map[uint]error) map[uint]string {
+	codes := make(map[uint]string)
+	for userID, err := range rejected {
+		codes[userID] = err.Error()
+	}
+	return codes
+}
+
+//firstRejection returns the error code of the first rejected user in the
+//order the client sent them
+func firstRejection(userIDs []uint, rejected map[uint]error) string {
+	for _, userID := range userIDs {
+		if err, ok := rejected[userID]; ok {
+			return err.Error()
+		}
+	}
+	return "invalid_request"
+}
+
 func (LifeRequestCtrl LifeRequestCtrl) createInstallRequest(res http.ResponseWriter, req *http.Request) {
 	r := render.New(render.Options{})
 	user, _ := middlewares.GetUserFromContext(res, req)
@@ -149,6 +185,11 @@ func (LifeRequestCtrl LifeRequestCtrl) createInstallFromRequest(res http.Respons
 	var lifeRequest models.LifeRequest
 	lifeRequest.CreateInstallFromRequest(data.Guid, user.ID)
 
+	// the install request guid also counts as invite of a new user
+	if _, err := user.AttributeReferral("", data.Guid, user.DeviceID); err != nil {
+		l4g.Debug("install request of user %d not attributed: %v", user.ID, err)
+	}
+
 	r.JSON(res, 200, map[string]string{})
 	return
 }
@@ -0,0 +1,142 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitMerge(r *mux.Router) {
+	l4g.Debug("Initializing v2 merge api routes")
+	mergeController := MergeCtrl{}
+	sr := r.PathPrefix("/merge").Subrouter()
+	sr.Handle("/preview", api.ApiTokenRequired(mergeController.Preview)).Methods("POST")
+	sr.Handle("/", api.ApiTokenRequired(mergeController.Merge)).Methods("POST")
+}
+
+//MergeCtrl handels /merge, the current user is merged into the account the
+//proof belongs to
+type MergeCtrl struct{}
+
+type mergeResponse struct {
+	User  models.User         `json:"user"`
+	Token models.AuthToken    `json:"token"`
+	Merge models.AccountMerge `json:"merge"`
+}
+
+//writeMergeError maps the merge errors to status codes
+func writeMergeError(r *render.Render, res http.ResponseWriter, req *http.Request, err error) {
+	switch err {
+	case models.ErrAccountProofRequired:
+		r.JSON(res, 403, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	case models.ErrCanNotMergeSameUser:
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	case models.ErrTooManyMergeAttempts:
+		r.JSON(res, 429, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	default:
+		writeIdentityError(r, res, req, err)
+	}
+}
+
+//Preview shows what would move to the other account
+func (mergeCtrl MergeCtrl) Preview(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var proof models.MergeProof
+	if err := decoder.Decode(&proof); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	target, _, err := models.FindMergeTarget(proof, helpers.ClientIP(req), false)
+	if err != nil {
+		writeMergeError(r, res, req, err)
+		return
+	}
+
+	preview, err := currentUser.PreviewMerge(target)
+	if err != nil {
+		writeMergeError(r, res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, preview)
+}
+
+//Merge moves the current user into the other account and answers with a
+//token of that account
+func (mergeCtrl MergeCtrl) Merge(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var proof models.MergeProof
+	if err := decoder.Decode(&proof); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	target, proofType, err := models.FindMergeTarget(proof, helpers.ClientIP(req), true)
+	if err != nil {
+		writeMergeError(r, res, req, err)
+		return
+	}
+
+	response, err := mergeAccounts(currentUser, target, proofType)
+	if err != nil {
+		writeMergeError(r, res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, response)
+}
+
+//mergeAccounts merges the source into the target and issues a token for the
+//target
+func mergeAccounts(source, target models.User, proofType string) (mergeResponse, error) {
+	accountMerge, err := source.MergeInto(&target, proofType)
+	if err != nil {
+		return mergeResponse{}, err
+	}
+
+	tokenString, err := helpers.GenerateJWTToken()
+	if err != nil {
+		return mergeResponse{}, err
+	}
+
+	token := models.AuthToken{
+		Token:     tokenString,
+		UserRefer: target.ID,
+	}
+
+	target.AppendAuthToken(token)
+	if err := target.Save(); err != nil {
+		return mergeResponse{}, err
+	}
+
+	return mergeResponse{
+		User:  target,
+		Token: token,
+		Merge: accountMerge,
+	}, nil
+}
@@ -23,6 +23,8 @@ func InitProfile(r *mux.Router) {
 	sr.Handle("/", api.ApiTokenRequired(profileController.List)).Methods("GET")
 	sr.Handle("/", api.ApiTokenRequired(profileController.Update)).Methods("PUT")
 	sr.Handle("/language", api.ApiTokenRequired(profileController.SetLanguage)).Methods("PUT")
+	sr.Handle("/discoverable", api.ApiTokenRequired(profileController.SetDiscoverable)).Methods("PUT")
+	sr.Handle("/shareactivity", api.ApiTokenRequired(profileController.SetShareActivity)).Methods("PUT")
 	sr.Handle("/verifyemail", api.ApiTokenRequired(profileController.VerifyEmail)).Methods("POST")
 	sr.Handle("/pushtoken", api.ApiTokenRequired(profileController.SetPushToken)).Methods("POST")
 	sr.Handle("/pushtoken", api.ApiTokenRequired(profileController.DeletePushToken)).Methods("PUT")
@@ -42,6 +44,11 @@ func (profileCtrl ProfileCtrl) List(res http.ResponseWriter, req *http.Request)
 		return
 	}
 
+	if err := currentUser.RefreshLives(); err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
 	r.JSON(res, 200, currentUser)
 	return
 }
@@ -128,6 +135,7 @@ func (profileCtrl ProfileCtrl) VerifyEmail(res http.ResponseWriter, req *http.Re
 	}
 
 	currentUser.Email = email
+	currentUser.MarkEmailVerified()
 	currentUser.Guest = false
 	if err := currentUser.Save(); err != nil {
 		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
@@ -245,3 +253,62 @@ func (profileCtrl ProfileCtrl) SetLanguage(res http.ResponseWriter, req *http.Re
 	r.JSON(res, 200, currentUser)
 	return
 }
+
+type setDiscoverableRequestTemplate struct {
+	Discoverable bool `json:"discoverable"`
+}
+
+//SetDiscoverable /profile/discoverable (PUT) handler
+func (profileCtrl ProfileCtrl) SetDiscoverable(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var setDiscoverableRequestData setDiscoverableRequestTemplate
+	if err := decoder.Decode(&setDiscoverableRequestData); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser.Discoverable = setDiscoverableRequestData.Discoverable
+	if err := currentUser.Save(); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, currentUser)
+}
+
+type setShareActivityRequestTemplate struct {
+	ShareActivity bool `json:"shareActivity"`
+}
+
+//SetShareActivity /profile/shareactivity (PUT) handler
+func (profileCtrl ProfileCtrl) SetShareActivity(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var setShareActivityRequestData setShareActivityRequestTemplate
+	if err := decoder.Decode(&setShareActivityRequestData); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if err := currentUser.SetShareActivity(setShareActivityRequestData.ShareActivity); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, currentUser)
+}
@@ -0,0 +1,99 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+	"gopkg.in/asaskevich/govalidator.v4"
+)
+
+func InitPurchases(r *mux.Router) {
+	l4g.Debug("Initializing v2 purchases api routes")
+	purchasesController := PurchasesCtrl{}
+	sr := r.PathPrefix("/purchases").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(purchasesController.List)).Methods("GET")
+	sr.Handle("/", api.ApiTokenRequired(purchasesController.Create)).Methods("POST")
+}
+
+//PurchasesCtrl handels /purchases
+type PurchasesCtrl struct{}
+
+type createPurchaseRequestData struct {
+	Platform  string `json:"platform" valid:"required"`
+	ProductID string `json:"productId" valid:"required"`
+	Receipt   string `json:"receipt" valid:"required"`
+}
+
+type purchaseResponse struct {
+	Coins    int             `json:"coins"`
+	Purchase models.Purchase `json:"purchase"`
+}
+
+//List returns the purchases of the current user
+func (purchasesCtrl PurchasesCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var purchase models.Purchase
+	purchases, err := purchase.FindByUserID(currentUser.ID)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, purchases)
+}
+
+//Create validates a store receipt and credits the coins of the coin pack
+func (purchasesCtrl PurchasesCtrl) Create(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var createPurchaseRequest createPurchaseRequestData
+	if err := decoder.Decode(&createPurchaseRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(createPurchaseRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	purchase, err := currentUser.RedeemPurchase(createPurchaseRequest.Platform, createPurchaseRequest.ProductID, createPurchaseRequest.Receipt)
+	if err != nil {
+		status := 422
+		if err == models.ErrReceiptUnavailable {
+			status = 503
+		}
+		r.JSON(res, status, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	// reload the balance, the purchase might have been redeemed before
+	currentUser.FindByID(currentUser.ID)
+
+	r.JSON(res, 200, purchaseResponse{
+		Coins:    currentUser.Coins,
+		Purchase: purchase,
+	})
+}
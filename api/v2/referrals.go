@@ -0,0 +1,85 @@
+package v2
+
+import (
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitReferrals(r *mux.Router) {
+	l4g.Debug("Initializing v2 referrals api routes")
+	referralsController := ReferralsCtrl{}
+	sr := r.PathPrefix("/referrals").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(referralsController.List)).Methods("GET")
+	sr.Handle("/links", api.ApiTokenRequired(referralsController.CreateLink)).Methods("POST")
+}
+
+//ReferralsCtrl handels /referrals
+type ReferralsCtrl struct{}
+
+type referralsResponse struct {
+	Code      string            `json:"code"`
+	Referrals []models.Referral `json:"referrals"`
+}
+
+//List returns the invite code of the current user and the users they invited
+func (referralsCtrl ReferralsCtrl) List(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	referralCode, err := currentUser.GetReferralCode()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var referral models.Referral
+	referrals, err := referral.FindByReferrer(currentUser.ID)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	parsedReferrals := []models.Referral{}
+	for _, referral := range referrals {
+		referral.Invitee.FindByID(referral.InviteeRefer)
+		referral.Invitee.Email = ""
+		parsedReferrals = append(parsedReferrals, referral)
+	}
+
+	r.JSON(res, 200, referralsResponse{
+		Code:      referralCode.Code,
+		Referrals: parsedReferrals,
+	})
+}
+
+//CreateLink creates a deep-link token the current user can share
+func (referralsCtrl ReferralsCtrl) CreateLink(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	referralLink, err := currentUser.CreateReferralLink()
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 201, referralLink)
+}
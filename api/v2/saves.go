@@ -0,0 +1,159 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"timedrop/api"
+	"timedrop/config"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitSaves(r *mux.Router) {
+	l4g.Debug("Initializing v2 saves api routes")
+	savesController := SavesCtrl{}
+	sr := r.PathPrefix("/saves").Subrouter()
+	sr.Handle("/{key:[a-zA-Z]+}", api.ApiTokenRequired(savesController.Get)).Methods("GET")
+	sr.Handle("/{key:[a-zA-Z]+}", api.ApiTokenRequired(savesController.Put)).Methods("PUT")
+	sr.Handle("/{key:[a-zA-Z]+}", api.ApiTokenRequired(savesController.Patch)).Methods("PATCH")
+}
+
+//SavesCtrl handels /saves
+type SavesCtrl struct{}
+
+type saveRequestData struct {
+	// Version the change is based on, 0 if the save doesn't exist yet
+	Version int             `json:"version"`
+	Data    json.RawMessage `json:"data"`
+}
+
+type saveResponse struct {
+	Key       string          `json:"key"`
+	Version   int             `json:"version"`
+	Data      json.RawMessage `json:"data"`
+	UpdatedAt time.Time       `json:"updatedAt"`
+}
+
+func newSaveResponse(save models.Save) saveResponse {
+	return saveResponse{
+		Key:       save.SaveKey,
+		Version:   save.Version,
+		Data:      json.RawMessage(save.Data),
+		UpdatedAt: save.UpdatedAt,
+	}
+}
+
+//renderSaveError maps the save errors to their status codes
+func renderSaveError(res http.ResponseWriter, req *http.Request, err error) {
+	r := render.New(render.Options{})
+
+	switch err := err.(type) {
+	case models.SaveValidationError:
+		response := helpers.GenerateErrorResponse(err.Error(), req.Header)
+		for _, detail := range err.Details {
+			response.Errors = append(response.Errors, detail)
+		}
+		r.JSON(res, 422, response)
+		return
+	}
+
+	status := 500
+	switch err {
+	case models.ErrSaveKeyUnknown, models.ErrSaveNotFound:
+		status = 404
+	case models.ErrSaveVersionConflict:
+		status = 409
+	case models.ErrSaveTooLarge:
+		status = 413
+	}
+	r.JSON(res, status, helpers.GenerateErrorResponse(err.Error(), req.Header))
+}
+
+//decodeSaveRequest reads the body, limited to a little more than the max save size
+func decodeSaveRequest(res http.ResponseWriter, req *http.Request) (saveRequestData, error) {
+	var saveRequest saveRequestData
+
+	body := req.Body
+	if maxSize := config.Cfg.SaveSettings.MaxSizeBytes; maxSize > 0 {
+		body = http.MaxBytesReader(res, req.Body, int64(2*maxSize))
+	}
+
+	err := json.NewDecoder(body).Decode(&saveRequest)
+	return saveRequest, err
+}
+
+//Get returns the save of the current user for the key
+func (savesCtrl SavesCtrl) Get(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	save, err := currentUser.GetSave(mux.Vars(req)["key"])
+	if err != nil {
+		renderSaveError(res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, newSaveResponse(save))
+}
+
+//Put replaces the save if it is still at the sent version
+func (savesCtrl SavesCtrl) Put(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	saveRequest, err := decodeSaveRequest(res, req)
+	if err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	save, err := currentUser.PutSave(mux.Vars(req)["key"], saveRequest.Version, saveRequest.Data)
+	if err != nil {
+		renderSaveError(res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, newSaveResponse(save))
+}
+
+//Patch merges the sent data into the save if it is still at the sent version
+func (savesCtrl SavesCtrl) Patch(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	saveRequest, err := decodeSaveRequest(res, req)
+	if err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	save, err := currentUser.PatchSave(mux.Vars(req)["key"], saveRequest.Version, saveRequest.Data)
+	if err != nil {
+		renderSaveError(res, req, err)
+		return
+	}
+
+	r.JSON(res, 200, newSaveResponse(save))
+}
@@ -6,7 +6,7 @@ import (
 
 	"timedrop/api"
 	"timedrop/helpers"
-	"timedrop/models"
+	"timedrop/middlewares"
 
 	l4g "github.com/alecthomas/log4go"
 	"gopkg.in/asaskevich/govalidator.v4"
@@ -28,13 +28,18 @@ type SearchCtrl struct{}
 
 type searchRequestData struct {
 	Data string `json:"data" valid:"required"`
+	// Page starts at 0
+	Page int `json:"page"`
 }
 
 type searchResult struct {
-	ID       uint
-	Username string
-	Level    string
-	Score    int
+	ID            uint
+	Username      string
+	Level         string
+	Score         int
+	FbName        string
+	IsFriend      bool
+	MutualFriends int
 }
 
 //Search handels /search
@@ -59,17 +64,32 @@ func (searchCtrl SearchCtrl) Search(res http.ResponseWriter, req *http.Request)
 		return
 	}
 
-	var userRes []models.User
-	db := models.GetDatabaseSession()
-	db.Where("email LIKE ? OR username LIKE ?", "%"+searchRequest.Data+"%", "%"+searchRequest.Data+"%").Find(&userRes)
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if searchRequest.Page < 0 {
+		searchRequest.Page = 0
+	}
+
+	foundUsers, err := currentUser.SearchUsers(searchRequest.Data, searchRequest.Page)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
 
-	var parsedUsers []searchResult
-	for _, user := range userRes {
+	parsedUsers := []searchResult{}
+	for _, foundUser := range foundUsers {
 		parsedUser := searchResult{
-			Username: user.Username,
-			ID:       user.ID,
-			Level:    user.Level,
-			Score:    user.Score,
+			Username:      foundUser.User.Username,
+			ID:            foundUser.User.ID,
+			Level:         foundUser.User.Level,
+			Score:         foundUser.User.Score,
+			FbName:        foundUser.User.FbName,
+			IsFriend:      foundUser.IsFriend,
+			MutualFriends: foundUser.MutualFriends,
 		}
 		parsedUsers = append(parsedUsers, parsedUser)
 	}
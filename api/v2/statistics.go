@@ -46,7 +46,7 @@ func (statisticsCtrl StatisticsCtrl) TopList(res http.ResponseWriter, req *http.
 
 	db := models.GetDatabaseSession()
 	var users []models.User
-	db.Where("level_refer = ?", topListRankRefer).Order("score desc").Limit(20).Find(&users)
+	db.Where("level_refer = ? AND is_bot = 0", topListRankRefer).Order("score desc").Limit(20).Find(&users)
 
 	r.JSON(res, 200, users)
 }
@@ -67,7 +67,7 @@ func (statisticsCtrl StatisticsCtrl) Rank(res http.ResponseWriter, req *http.Req
 	}
 
 	db := models.GetDatabaseSession()
-	sqlQuery := "SELECT *, @curRank := @curRank + 1 AS rank FROM users p, (SELECT @curRank := 0) r WHERE `level_refer` = ? ORDER BY score DESC;"
+	sqlQuery := "SELECT *, @curRank := @curRank + 1 AS rank FROM users p, (SELECT @curRank := 0) r WHERE `level_refer` = ? AND `is_bot` = 0 ORDER BY score DESC;"
 
 	var results []userRankResult
 	db.Raw(sqlQuery, currentUser.LevelRefer).Scan(&results)
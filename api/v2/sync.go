@@ -0,0 +1,67 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/middlewares"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+)
+
+func InitSync(r *mux.Router) {
+	l4g.Debug("Initializing v2 sync api routes")
+	syncController := SyncCtrl{}
+	sr := r.PathPrefix("/sync").Subrouter()
+	sr.Handle("/", api.ApiTokenRequired(syncController.Sync)).Methods("POST")
+}
+
+//SyncCtrl handels /sync
+type SyncCtrl struct{}
+
+type syncRequestData struct {
+	// BaseRevision is the syncRevision the client changes are based on
+	BaseRevision int                        `json:"baseRevision"`
+	Changes      map[string]json.RawMessage `json:"changes"`
+}
+
+//Sync applies the field changes of a device and returns the merged user
+//together with the fields which were changed by another device meanwhile
+func (syncCtrl SyncCtrl) Sync(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var syncRequest syncRequestData
+	if err := decoder.Decode(&syncRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	currentUser, err := middlewares.GetUserFromContext(res, req)
+	if err != nil {
+		r.JSON(res, 500, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	syncResult, err := currentUser.Sync(syncRequest.BaseRevision, syncRequest.Changes)
+	if _, ok := err.(models.SaveValidationError); ok || err == models.ErrSaveTooLarge {
+		renderSaveError(res, req, err)
+		return
+	}
+	if err != nil {
+		status := 500
+		switch err {
+		case models.ErrSyncFieldUnknown, models.ErrSyncFieldInvalid, models.ErrSyncRevisionInvalid:
+			status = 422
+		}
+		r.JSON(res, status, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	r.JSON(res, 200, syncResult)
+}
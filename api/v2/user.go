@@ -3,6 +3,7 @@ package v2
 import (
 	"encoding/json"
 	"net/http"
+	"strconv"
 	"timedrop/api"
 	"timedrop/helpers"
 	"timedrop/models"
@@ -15,11 +16,12 @@ import (
 type UserCtrl struct {
 	ChangeTo       string `json:"changeTo"`
 	InvalidateUser string `json:"invalidateUser"`
-	FacebookId     string `json:"facebookId"`
 	FbName         string `json:"fbName"`
 	FbImage        string `json:"fbImage"`
 	Email          string `json:"email"`
 	VerifyCode     string `json:"verifyCode"`
+	// FacebookAccessToken proves the Facebook account of the user to change to
+	FacebookAccessToken string `json:"facebookAccessToken"`
 }
 
 type ResultChangeUser struct {
@@ -34,6 +36,8 @@ func InitUser(r *mux.Router) {
 
 }
 
+//change merges the current user into the account it changes to, the client
+//has to prove it owns that account with Facebook or a login code
 func (User UserCtrl) change(res http.ResponseWriter, req *http.Request) {
 	r := render.New(render.Options{})
 
@@ -49,38 +53,41 @@ func (User UserCtrl) change(res http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	var resultUser ResultChangeUser
-	if err := resultUser.User.FindByID(User.ChangeTo); err != nil {
-		r.JSON(res, 404, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	// only the own account can be given up
+	currentUser := req.Context().Value("user").(models.User)
+	if User.InvalidateUser != strconv.Itoa(int(currentUser.ID)) {
+		r.JSON(res, 403, helpers.GenerateErrorResponse("account_proof_required", req.Header))
 		return
 	}
 
-	var invalidateUser models.User
-	if err := invalidateUser.FindByID(User.InvalidateUser); err != nil {
-		r.JSON(res, 404, helpers.GenerateErrorResponse(err.Error(), req.Header))
-		return
+	proof := models.MergeProof{
+		Email:      User.Email,
+		VerifyCode: User.VerifyCode,
 	}
-
-	if User.FacebookId != "" {
-		//delete by facebook id
-		invalidateUser.DeleteFacebookData()
-	} else if User.Email != "" && User.VerifyCode != "" {
-		//delete by email
-		invalidateUser.DeleteEmailData()
+	if User.FacebookAccessToken != "" {
+		proof.Provider = models.IdentityProviderFacebook
+		proof.Token = User.FacebookAccessToken
 	}
 
-	var authToken models.AuthToken
-	tokenId := resultUser.User.FindTokenIdByUserId()
-
-	if err := authToken.FindByUserRefer(tokenId); err != nil {
+	target, proofType, err := models.FindMergeTarget(proof, helpers.ClientIP(req), true)
+	if err != nil {
+		writeMergeError(r, res, req, err)
+		return
+	}
+	if User.ChangeTo != strconv.Itoa(int(target.ID)) {
+		r.JSON(res, 403, helpers.GenerateErrorResponse("account_proof_required", req.Header))
+		return
+	}
 
-		r.JSON(res, 404, helpers.GenerateErrorResponse(err.Error(), req.Header))
+	merged, err := mergeAccounts(currentUser, target, proofType)
+	if err != nil {
+		writeMergeError(r, res, req, err)
 		return
 	}
 
 	response := ResultChangeUser{
-		User:  resultUser.User,
-		Token: authToken,
+		User:  merged.User,
+		Token: merged.Token,
 	}
 	r.JSON(res, 200, response)
 	return
@@ -0,0 +1,60 @@
+package v2
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"timedrop/api"
+	"timedrop/helpers"
+	"timedrop/models"
+
+	l4g "github.com/alecthomas/log4go"
+	"github.com/gorilla/mux"
+	"github.com/unrolled/render"
+	"gopkg.in/asaskevich/govalidator.v4"
+)
+
+func InitUsers(r *mux.Router) {
+	l4g.Debug("Initializing v2 users api routes")
+	usersController := UsersCtrl{}
+	sr := r.PathPrefix("/users").Subrouter()
+	sr.Handle("/{userID:[0-9]+}/rename", api.ApiAdminRequired(usersController.Rename)).Methods("POST")
+}
+
+//UsersCtrl handels /users
+type UsersCtrl struct{}
+
+type renameUserRequestData struct {
+	Username string `json:"username" valid:"required"`
+}
+
+//Rename replaces the username of a user, the user gets notified
+func (usersCtrl UsersCtrl) Rename(res http.ResponseWriter, req *http.Request) {
+	r := render.New(render.Options{})
+
+	decoder := json.NewDecoder(req.Body)
+	var renameUserRequest renameUserRequestData
+	if err := decoder.Decode(&renameUserRequest); err != nil {
+		r.JSON(res, 400, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	if _, err := govalidator.ValidateStruct(renameUserRequest); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	var user models.User
+	if err := user.FindByID(mux.Vars(req)["userID"]); err != nil {
+		r.JSON(res, 404, helpers.GenerateErrorResponse("user_not_found", req.Header))
+		return
+	}
+
+	if err := user.AdminRename(renameUserRequest.Username); err != nil {
+		r.JSON(res, 422, helpers.GenerateErrorResponse(err.Error(), req.Header))
+		return
+	}
+
+	user.Email = ""
+	r.JSON(res, 200, user)
+}
@@ -0,0 +1,98 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+
+	l4g "github.com/alecthomas/log4go"
+)
+
+var (
+	AchievementTypeGamesPlayed   = "games_played"
+	AchievementTypeGamesWon      = "games_won"
+	AchievementTypeLevelReached  = "level_reached"
+	AchievementTypeFriendsBeaten = "friends_beaten"
+	AchievementTypeFriends       = "friends"
+)
+
+var AchievementTypes = []string{
+	AchievementTypeGamesPlayed,
+	AchievementTypeGamesWon,
+	AchievementTypeLevelReached,
+	AchievementTypeFriendsBeaten,
+	AchievementTypeFriends,
+}
+
+var Achievements []AchievementDefinition
+
+type AchievementDefinition struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Target is the count to reach, for level_reached it is the level order
+	Target int `json:"target"`
+
+	RewardCoins int `json:"rewardCoins"`
+	RewardLives int `json:"rewardLives"`
+}
+
+type achievementsFile struct {
+	Achievements []AchievementDefinition `json:"achievements"`
+}
+
+func LoadAchievements(filePath string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		panic("Error opening achievements file " + filePath + "\nerror: " + err.Error())
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	data := achievementsFile{}
+	err = decoder.Decode(&data)
+	if err != nil {
+		panic("Error decoding achievements file " + filePath + "\nerror: " + err.Error())
+	}
+
+	if err := ValidateAchievements(data.Achievements); err != nil {
+		panic("Invalid achievements file " + filePath + "\nerror: " + err.Error())
+	}
+	l4g.Info("Successfully loaded %d achievements", len(data.Achievements))
+
+	Achievements = data.Achievements
+}
+
+//ValidateAchievements checks that keys are unique and every achievement has
+//a known type and a reachable target
+func ValidateAchievements(achievements []AchievementDefinition) error {
+	keys := make(map[string]bool)
+	for _, achievement := range achievements {
+		if achievement.Key == "" {
+			return fmt.Errorf("achievement %q has no key", achievement.Name)
+		}
+		if keys[achievement.Key] {
+			return fmt.Errorf("achievement key %q is used twice", achievement.Key)
+		}
+		keys[achievement.Key] = true
+
+		knownType := false
+		for _, achievementType := range AchievementTypes {
+			if achievement.Type == achievementType {
+				knownType = true
+			}
+		}
+		if !knownType {
+			return fmt.Errorf("achievement %q has the unknown type %q", achievement.Key, achievement.Type)
+		}
+
+		if achievement.Target <= 0 {
+			return fmt.Errorf("achievement %q needs a positive target", achievement.Key)
+		}
+		if achievement.RewardCoins < 0 || achievement.RewardLives < 0 {
+			return fmt.Errorf("achievement %q has negative rewards", achievement.Key)
+		}
+	}
+
+	return nil
+}
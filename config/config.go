@@ -11,19 +11,216 @@ import (
 var Cfg *Config = &Config{}
 
 type Config struct {
-	ServiceSettings  ServiceSettings
-	LogSettings      LogSettings
-	DatabaseSettings DatabaseSettings
+	ServiceSettings       ServiceSettings
+	LogSettings           LogSettings
+	DatabaseSettings      DatabaseSettings
+	GameSettings          GameSettings
+	PurchaseSettings      PurchaseSettings
+	LifeRequestSettings   LifeRequestSettings
+	ReferralSettings      ReferralSettings
+	SaveSettings          SaveSettings
+	DailySettings         DailySettings
+	GroupGameSettings     GroupGameSettings
+	ReplaySettings        ReplaySettings
+	BotSettings           BotSettings
+	UsernameSettings      UsernameSettings
+	SearchSettings        SearchSettings
+	SuggestionSettings    SuggestionSettings
+	FeedSettings          FeedSettings
+	FriendRequestSettings FriendRequestSettings
+	ContactSettings       ContactSettings
+	IdentitySettings      IdentitySettings
+	AccountSettings       AccountSettings
 }
 
 type ServiceSettings struct {
 	ListenAddress string
+	AdminToken    string
 }
 
 type LogSettings struct {
 	EnableConsole bool
 }
 
+type GameSettings struct {
+	LevelsFile       string
+	AchievementsFile string
+
+	MaxLives                int
+	LifeRegenerationMinutes int
+}
+
+// a limit of 0 disables the check
+type LifeRequestSettings struct {
+	MaxRequestsSentPerDay     int
+	MaxRequestsReceivedPerDay int
+	MaxGiftsPerDay            int
+	RequestCooldownMinutes    int
+}
+
+type ReferralSettings struct {
+	// RequiredGames the invitee has to complete before both get rewarded
+	RequiredGames int
+
+	ReferrerCoins int
+	ReferrerLives int
+	InviteeCoins  int
+	InviteeLives  int
+
+	LinkExpiryDays int
+	// AttributionWindowHours is how long after registering an invite can
+	// still be attributed, e.g. through an install request
+	AttributionWindowHours int
+	LinkBaseURL            string
+}
+
+type DailySettings struct {
+	// SeedSecret keeps the upcoming daily challenges unpredictable, in
+	// production it comes from TIMEDROP_SEED_SECRET
+	SeedSecret string
+	MapCount   int
+
+	RewardCoins int
+	// StreakBonusCoins are added per consecutive day, up to MaxStreakBonusDays
+	StreakBonusCoins   int
+	MaxStreakBonusDays int
+
+	LeaderboardSize int
+	// MaxDurationMinutes after starting in which the result has to be sent
+	MaxDurationMinutes int
+}
+
+type GroupGameSettings struct {
+	// MaxParticipants including the creator
+	MaxParticipants int
+	// WindowHours after creation in which all participants have to play
+	WindowHours int
+	// CoinsPerParticipant are added to the pot which is split by rank
+	CoinsPerParticipant int
+}
+
+type BotSettings struct {
+	Enabled bool
+	// Usernames of the bot users, they are created on startup
+	Usernames []string
+	// TakeOverMinutes a finished game waits for a human opponent
+	TakeOverMinutes int
+	// MinScoreSamples needed before the score distribution of a level is used
+	MinScoreSamples int
+}
+
+type UsernameSettings struct {
+	MinLength int
+	MaxLength int
+	// WordsFile lists the reserved words and the profanity per language
+	WordsFile string
+}
+
+type SearchSettings struct {
+	PageSize int
+	// MaxCandidates limits the index rows ranked for one query
+	MaxCandidates int
+}
+
+type SuggestionSettings struct {
+	Limit int
+	// weights per mutual friend, per game against the user and per contact
+	MutualFriendWeight int
+	OpponentWeight     int
+	ContactWeight      int
+	// OpponentDays games are taken into account
+	OpponentDays int
+}
+
+type FeedSettings struct {
+	PageSize      int
+	RetentionDays int
+}
+
+type FriendRequestSettings struct {
+	// ExpiryDays a request stays pending without an answer
+	ExpiryDays int
+	// DeclineCooldownDays before a declined requester can ask again
+	DeclineCooldownDays int
+}
+
+type ContactSettings struct {
+	// HashSalt is shared with the clients, the stored hashes are updated on
+	// startup when it changes. In production it comes from
+	// TIMEDROP_CONTACT_HASH_SALT
+	HashSalt           string
+	MaxHashesPerImport int
+	// MaxHashesPerDay limits the hashes a user can import within 24 hours
+	MaxHashesPerDay int
+}
+
+type IdentitySettings struct {
+	// UseFakeProviders accepts "fake:<subject>[:<email>]" tokens, never enable it in production
+	UseFakeProviders bool
+
+	// the audiences our ID tokens are issued for, per provider
+	AppleClientIDs  []string
+	GoogleClientIDs []string
+	FacebookAppIDs  []string
+
+	// FacebookAppSecret and FacebookGraphURL are used to check Facebook access tokens
+	FacebookAppSecret string
+	FacebookGraphURL  string
+
+	JWKSCacheMinutes int
+}
+
+type AccountSettings struct {
+	// DeletionGraceDays a deleted account can still be restored
+	DeletionGraceDays int
+	// DeletedUsername is the user which replaces deleted users in games
+	DeletedUsername string
+
+	// LoginCodeMinutes a login code can be used as proof for a merge
+	LoginCodeMinutes int
+	// wrong login codes allowed per target account and per IP within
+	// MergeAttemptWindowMinutes, a limit of 0 disables the check
+	MaxMergeAttemptsPerTarget int
+	MaxMergeAttemptsPerIP     int
+	MergeAttemptWindowMinutes int
+}
+
+type ReplaySettings struct {
+	// MaxSizeBytes limits the compressed replay as uploaded
+	MaxSizeBytes int
+	// MaxUncompressedBytes guards against replays which inflate too much
+	MaxUncompressedBytes int
+	RetentionDays        int
+}
+
+type SaveSettings struct {
+	// MaxSizeBytes limits the data of a single save, 0 disables the check
+	MaxSizeBytes int
+	// Schemas maps every allowed save key to its JSON schema file
+	Schemas map[string]string
+}
+
+type PurchaseSettings struct {
+	// UseFakeVerifier accepts "fake:<transactionId>" receipts, never enable it in production
+	UseFakeVerifier bool
+
+	AppStoreVerifyURL    string
+	AppStoreSandboxURL   string
+	AppStoreSharedSecret string
+	// AppStoreBundleID receipts have to be issued for
+	AppStoreBundleID string
+
+	GooglePlayPackageName        string
+	GooglePlayServiceAccountFile string
+
+	// AcceptSandboxReceipts credits App Store sandbox and Google Play test
+	// purchases, they are marked as sandbox
+	AcceptSandboxReceipts bool
+
+	// CoinPacks maps store product ids to the amount of coins they credit
+	CoinPacks map[string]int
+}
+
 type DatabaseSettings struct {
 	DatabaseUsername   string
 	DatabasePassword   string
@@ -53,4 +250,44 @@ func LoadConfig(filePath string) {
 	fmt.Println("DEBUGconfig!", config)
 
 	Cfg = &config
+	Cfg.loadSecrets()
+	Cfg.checkSecrets()
+
+	if Cfg.GameSettings.LevelsFile != "" {
+		LoadLevels(Cfg.GameSettings.LevelsFile)
+	}
+	if Cfg.GameSettings.AchievementsFile != "" {
+		LoadAchievements(Cfg.GameSettings.AchievementsFile)
+	}
+	if Cfg.UsernameSettings.WordsFile != "" {
+		LoadUsernameWords(Cfg.UsernameSettings.WordsFile)
+	}
+}
+
+// the secrets are not committed with the prod config, they are set in the
+// environment of the server instead
+const (
+	envSeedSecret = "TIMEDROP_SEED_SECRET"
+	envHashSalt   = "TIMEDROP_CONTACT_HASH_SALT"
+)
+
+//loadSecrets takes the secrets from the environment, they override the
+//config file
+func (config *Config) loadSecrets() {
+	if seedSecret := os.Getenv(envSeedSecret); seedSecret != "" {
+		config.DailySettings.SeedSecret = seedSecret
+	}
+	if hashSalt := os.Getenv(envHashSalt); hashSalt != "" {
+		config.ContactSettings.HashSalt = hashSalt
+	}
+}
+
+//checkSecrets stops the server when a secret it can't run without is missing
+func (config *Config) checkSecrets() {
+	if config.DailySettings.SeedSecret == "" {
+		panic("DailySettings.SeedSecret is empty, set " + envSeedSecret + ", the daily challenges would be predictable")
+	}
+	if config.ContactSettings.HashSalt == "" {
+		panic("ContactSettings.HashSalt is empty, set " + envHashSalt + ", contact hashes could be looked up in precomputed tables")
+	}
 }
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+
+	l4g "github.com/alecthomas/log4go"
+)
+
+var Levels []LevelDefinition
+
+//MaxLevelsBelowTop is how far a user can be demoted below their top level
+var MaxLevelsBelowTop int
+
+type LevelDefinition struct {
+	Name      string `json:"name"`
+	Order     int    `json:"order"`
+	FromScore int    `json:"fromScore"`
+	ToScore   int    `json:"toScore"`
+
+	// PromotionCoins are granted the first time a user reaches the level
+	PromotionCoins int `json:"promotionCoins"`
+	// ProtectionGames is the number of games after a promotion during
+	// which the user can't be demoted again
+	ProtectionGames int `json:"protectionGames"`
+}
+
+type levelsFile struct {
+	Levels            []LevelDefinition `json:"levels"`
+	MaxLevelsBelowTop int               `json:"maxLevelsBelowTop"`
+}
+
+func LoadLevels(filePath string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		panic("Error opening levels file " + filePath + "\nerror: " + err.Error())
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	data := levelsFile{}
+	err = decoder.Decode(&data)
+	if err != nil {
+		panic("Error decoding levels file " + filePath + "\nerror: " + err.Error())
+	}
+
+	if err := ValidateLevels(data.Levels); err != nil {
+		panic("Invalid levels file " + filePath + "\nerror: " + err.Error())
+	}
+	if data.MaxLevelsBelowTop < 0 {
+		panic("Invalid levels file " + filePath + "\nerror: maxLevelsBelowTop must not be negative")
+	}
+	l4g.Info("Successfully loaded %d levels", len(data.Levels))
+
+	Levels = data.Levels
+	MaxLevelsBelowTop = data.MaxLevelsBelowTop
+}
+
+//ValidateLevels checks that the levels cover the score range from 0 upwards
+//without gaps or overlaps and that names and orders are unique
+func ValidateLevels(levels []LevelDefinition) error {
+	if len(levels) == 0 {
+		return fmt.Errorf("no levels defined")
+	}
+
+	sorted := make([]LevelDefinition, len(levels))
+	copy(sorted, levels)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i].Order < sorted[j].Order
+	})
+
+	names := make(map[string]bool)
+	for i, level := range sorted {
+		if level.Name == "" {
+			return fmt.Errorf("level with order %d has no name", level.Order)
+		}
+		if names[level.Name] {
+			return fmt.Errorf("level name %q is used twice", level.Name)
+		}
+		names[level.Name] = true
+
+		if level.ToScore < level.FromScore {
+			return fmt.Errorf("level %q ends before it starts", level.Name)
+		}
+		if level.PromotionCoins < 0 || level.ProtectionGames < 0 {
+			return fmt.Errorf("level %q has negative rewards or protection", level.Name)
+		}
+
+		if i == 0 {
+			if level.FromScore != 0 {
+				return fmt.Errorf("first level %q must start at score 0", level.Name)
+			}
+			continue
+		}
+
+		previous := sorted[i-1]
+		if level.Order == previous.Order {
+			return fmt.Errorf("levels %q and %q share order %d", previous.Name, level.Name, level.Order)
+		}
+		if level.FromScore != previous.ToScore+1 {
+			return fmt.Errorf("level %q must start at %d, directly after %q", level.Name, previous.ToScore+1, previous.Name)
+		}
+	}
+
+	return nil
+}
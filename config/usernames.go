@@ -0,0 +1,46 @@
+package config
+
+import (
+	"encoding/json"
+	"os"
+
+	l4g "github.com/alecthomas/log4go"
+)
+
+var UsernameWords = UsernameWordList{}
+
+//UsernameWordList holds the words usernames are checked against
+type UsernameWordList struct {
+	// Reserved words can't be a username or a word of one
+	Reserved []string `json:"reserved"`
+	// Profanity maps a language to its blocked words, every language applies
+	// to every user since names are shown to all players. Like reserved words
+	// they only match whole words.
+	Profanity map[string][]string `json:"profanity"`
+	// Severe words are blocked anywhere in a username, even inside another
+	// word, so the list only holds words no harmless name contains
+	Severe []string `json:"severe"`
+}
+
+func LoadUsernameWords(filePath string) {
+	file, err := os.Open(filePath)
+	if err != nil {
+		panic("Error opening username words file " + filePath + "\nerror: " + err.Error())
+	}
+	defer file.Close()
+
+	decoder := json.NewDecoder(file)
+	data := UsernameWordList{}
+	err = decoder.Decode(&data)
+	if err != nil {
+		panic("Error decoding username words file " + filePath + "\nerror: " + err.Error())
+	}
+
+	count := len(data.Reserved) + len(data.Severe)
+	for _, words := range data.Profanity {
+		count += len(words)
+	}
+	l4g.Info("Successfully loaded %d username words", count)
+
+	UsernameWords = data
+}
@@ -1,11 +1,17 @@
 package helpers
 
 import (
+	cryptorand "crypto/rand"
+	"encoding/hex"
+	"math/big"
 	"math/rand"
 	"strconv"
 	"time"
 )
 
+// no 0/O and 1/I, codes are typed in by hand
+const inviteCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
 //GenerateOneTimeToken returns a random and fixed length int for login
 func GenerateOneTimeToken() string {
 	rand.Seed(time.Now().UTC().UnixNano())
@@ -16,3 +22,26 @@ func GenerateOneTimeToken() string {
 	randToken := low + rand.Intn(high-low)
 	return strconv.Itoa(randToken)
 }
+
+//GenerateInviteCode returns a random code which is easy to type
+func GenerateInviteCode(length int) string {
+	code := make([]byte, length)
+	max := big.NewInt(int64(len(inviteCodeAlphabet)))
+	for i := range code {
+		n, err := cryptorand.Int(cryptorand.Reader, max)
+		if err != nil {
+			panic(err)
+		}
+		code[i] = inviteCodeAlphabet[n.Int64()]
+	}
+	return string(code)
+}
+
+//GenerateSecureToken returns a random hex token with the given amount of bytes
+func GenerateSecureToken(bytes int) string {
+	token := make([]byte, bytes)
+	if _, err := cryptorand.Read(token); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(token)
+}
@@ -1,6 +1,7 @@
 package helpers
 
 import (
+	"net"
 	"net/http"
 
 	log "github.com/Sirupsen/logrus"
@@ -26,3 +27,12 @@ func GenerateErrorResponse(translationKey string, reqHeader http.Header) njr.JSO
 		Errors: []interface{}{translatedError},
 	}
 }
+
+//ClientIP returns the address the request came from without the port
+func ClientIP(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
@@ -5,6 +5,7 @@ import (
 	"os"
 	"os/signal"
 	"syscall"
+	"time"
 	"timedrop/api"
 	"timedrop/config"
 	"timedrop/helpers"
@@ -19,6 +20,39 @@ import (
 	"github.com/nicksnyder/go-i18n/i18n"
 )
 
+// how often the retention and expiry jobs run
+const cleanUpInterval = 10 * time.Minute
+
+//startCleanUpJobs runs the retention and expiry jobs one after another on a
+//ticker, so a slow run never overlaps the next one
+func startCleanUpJobs(interval time.Duration) {
+	var feedItem models.FeedItem
+	var friendRequest models.FriendRequest
+	var contactImport models.ContactImport
+	var accountDeletion models.AccountDeletion
+	var gameReplay models.GameReplay
+	var groupGame models.GroupGame
+	var mergeAttempt models.MergeAttempt
+	jobs := []func(){
+		feedItem.CleanUp,
+		friendRequest.CleanUp,
+		contactImport.CleanUp,
+		accountDeletion.CleanUp,
+		gameReplay.CleanUp,
+		groupGame.CleanUp,
+		mergeAttempt.CleanUp,
+	}
+
+	go func() {
+		ticker := time.NewTicker(interval)
+		for range ticker.C {
+			for _, job := range jobs {
+				job()
+			}
+		}
+	}()
+}
+
 func main() {
 	// set logging options
 	log.SetFormatter(&log.TextFormatter{})
@@ -28,9 +62,6 @@ func main() {
 	i18n.MustLoadTranslationFile("assets/i18n/en-US.all.json")
 	i18n.MustLoadTranslationFile("assets/i18n/de-DE.all.json")
 
-	// Bootstrap tables
-	models.Bootstrap()
-
 	// Auth mw bootstrap
 	jwtmiddleware.New(jwtmiddleware.Options{
 		ValidationKeyGetter: func(token *jwt.Token) (interface{}, error) {
@@ -53,6 +84,18 @@ func main() {
 		config.LoadConfig("config/config_prod.json")
 	}
 
+	// Bootstrap tables
+	models.Bootstrap()
+	models.InitReceiptVerifiers()
+	models.InitIdentityProviders()
+	models.InitSaveSchemas()
+	models.EnsureBots()
+	models.EnsureDeletedUser()
+	models.BackfillUsernameSkeletons()
+	models.BackfillSearchIndex()
+	models.BackfillContactHashes()
+	startCleanUpJobs(cleanUpInterval)
+
 	api.NewServer(port)
 	v1.InitApi()
 	v2.InitApi()
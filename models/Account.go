@@ -0,0 +1,243 @@
+package models
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"timedrop/config"
+
+	"github.com/jinzhu/gorm"
+)
+
+//AccountDeletion schedules the deletion of a user, the user can restore the
+//account until DeleteAfter
+type AccountDeletion struct {
+	BaseModel
+
+	UserRefer   uint      `json:"userId" gorm:";unique_index"`
+	DeleteAfter time.Time `json:"deleteAfter" sql:"index"`
+}
+
+//AccountExport is everything stored about a user, see ExportData
+type AccountExport struct {
+	ExportedAt       time.Time         `json:"exportedAt"`
+	Profile          accountProfile    `json:"profile"`
+	Identities       []UserIdentity    `json:"identities"`
+	Games            []Game            `json:"games"`
+	Friends          []User            `json:"friends"`
+	FriendRequests   []FriendRequest   `json:"friendRequests"`
+	LifeRequests     []LifeRequest     `json:"lifeRequests"`
+	PushTokens       []PushToken       `json:"pushTokens"`
+	Achievements     []UserAchievement `json:"achievements"`
+	CoinTransactions []CoinTransaction `json:"coinTransactions"`
+	Purchases        []Purchase        `json:"purchases"`
+	Saves            []Save            `json:"saves"`
+	Deletion         *AccountDeletion  `json:"deletion,omitempty"`
+}
+
+//accountProfile adds the fields the user json leaves out
+type accountProfile struct {
+	User
+	VerifiedEmail string `json:"verifiedEmail"`
+}
+
+//EnsureDeletedUser creates the user which takes the place of deleted users
+//in the games of their opponents, a real user with the configured name is never
+//taken over
+func EnsureDeletedUser() {
+	username := config.Cfg.AccountSettings.DeletedUsername
+	if username == "" {
+		return
+	}
+
+	var deletedUser User
+	deletedUser.FindByUsername(username)
+	if deletedUser.ID != 0 {
+		if !deletedUser.IsDeletedPlaceholder {
+			userLogger.Error(fmt.Sprintf("deleted username '%s' is taken by a real user", username))
+		}
+		return
+	}
+
+	deletedUser = User{
+		Username:             username,
+		Guest:                true,
+		IsDeletedPlaceholder: true,
+	}
+	if err := deletedUser.Save(); err != nil {
+		userLogger.Error(fmt.Sprintf("couldn't create deleted user '%s': %v", username, err))
+		return
+	}
+	// it never shows up in search or suggestions
+	GetDatabaseSession().Exec("UPDATE users SET discoverable = 0, share_activity = 0 WHERE id = ?", deletedUser.ID)
+}
+
+//ExportData collects the data of the user
+func (user *User) ExportData() (AccountExport, error) {
+	db := GetDatabaseSession()
+	userID := strconv.Itoa(int(user.ID))
+
+	export := AccountExport{
+		ExportedAt: time.Now(),
+		Profile: accountProfile{
+			User:          *user,
+			VerifiedEmail: user.VerifiedEmail,
+		},
+	}
+
+	queries := []*gorm.DB{
+		db.Where("user_refer = ?", user.ID).Order("id asc").Find(&export.Identities),
+		db.Where("creator_refer = ? OR opponent_refer = ?", user.ID, user.ID).Order("id asc").Find(&export.Games),
+		db.Where("id IN (SELECT receiver_refer FROM friends WHERE requester_refer = ? UNION SELECT requester_refer FROM friends WHERE receiver_refer = ?)",
+			user.ID, user.ID).Order("id asc").Find(&export.Friends),
+		db.Where("requester_refer = ? OR receiver_refer = ?", user.ID, user.ID).Order("id asc").Find(&export.FriendRequests),
+		db.Where("requester_refer = ? OR receiver_refer = ?", userID, userID).Order("id asc").Find(&export.LifeRequests),
+		db.Where("user_refer = ?", user.ID).Order("id asc").Find(&export.PushTokens),
+		db.Where("user_refer = ?", user.ID).Order("id asc").Find(&export.Achievements),
+		db.Where("user_refer = ?", user.ID).Order("id asc").Find(&export.CoinTransactions),
+		db.Where("user_refer = ?", user.ID).Order("id asc").Find(&export.Purchases),
+		db.Where("user_refer = ?", user.ID).Order("id asc").Find(&export.Saves),
+	}
+	for _, query := range queries {
+		if query.Error != nil {
+			return AccountExport{}, query.Error
+		}
+	}
+
+	// the export must not leak the emails of friends
+	for i := range export.Friends {
+		export.Friends[i].Email = ""
+	}
+
+	var accountDeletion AccountDeletion
+	db.Where("user_refer = ?", user.ID).First(&accountDeletion)
+	if accountDeletion.ID != 0 {
+		export.Deletion = &accountDeletion
+	}
+
+	return export, nil
+}
+
+//RequestDeletion schedules the deletion of the user after the grace period,
+//requesting it again keeps the first date
+func (user *User) RequestDeletion() (AccountDeletion, error) {
+	db := GetDatabaseSession()
+
+	var accountDeletion AccountDeletion
+	db.Where("user_refer = ?", user.ID).First(&accountDeletion)
+	if accountDeletion.ID != 0 {
+		return accountDeletion, nil
+	}
+
+	accountDeletion = AccountDeletion{
+		UserRefer:   user.ID,
+		DeleteAfter: time.Now().AddDate(0, 0, config.Cfg.AccountSettings.DeletionGraceDays),
+	}
+	if result := db.Create(&accountDeletion); result.Error != nil {
+		return AccountDeletion{}, result.Error
+	}
+
+	userLogger.Info(fmt.Sprintf("user '%d' requested the deletion of the account, deleted after %v", user.ID, accountDeletion.DeleteAfter))
+	return accountDeletion, nil
+}
+
+//CancelDeletion keeps the account of the user
+func (user *User) CancelDeletion() error {
+	db := GetDatabaseSession()
+	return db.Unscoped().Where("user_refer = ?", user.ID).Delete(AccountDeletion{}).Error
+}
+
+//DeleteAccount removes the user and everything stored about it. Games stay
+//for the opponents but point to the deleted user, purchases are kept without
+//the user for refunds.
+func (user *User) DeleteAccount() error {
+	tmpLog := userLogger.New("func", "DeleteAccount")
+
+	// a real user who happens to have the name must never get the games
+	var deletedUser User
+	deletedUser.FindByUsername(config.Cfg.AccountSettings.DeletedUsername)
+	if deletedUser.ID == 0 || !deletedUser.IsDeletedPlaceholder || deletedUser.ID == user.ID {
+		return fmt.Errorf("deleted user '%s' doesn't exist", config.Cfg.AccountSettings.DeletedUsername)
+	}
+
+	userID, deletedID := user.ID, deletedUser.ID
+	userRefer := strconv.Itoa(int(userID))
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// games nobody else played are removed, the others are anonymized
+		{"DELETE FROM games WHERE creator_refer = ? AND (opponent_refer = 0 OR opponent_refer = ?)", []interface{}{userID, userID}},
+		{"UPDATE games SET creator_refer = ? WHERE creator_refer = ?", []interface{}{deletedID, userID}},
+		{"UPDATE games SET opponent_refer = ? WHERE opponent_refer = ?", []interface{}{deletedID, userID}},
+		{"UPDATE games SET won_refer = ? WHERE won_refer = ?", []interface{}{deletedID, userID}},
+		{"UPDATE games SET lost_refer = ? WHERE lost_refer = ?", []interface{}{deletedID, userID}},
+		{"UPDATE group_games SET creator_refer = ? WHERE creator_refer = ?", []interface{}{deletedID, userID}},
+		{"UPDATE IGNORE game_participants SET user_refer = ? WHERE user_refer = ?", []interface{}{deletedID, userID}},
+		{"DELETE FROM game_participants WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM game_replays WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM daily_attempts WHERE user_refer = ?", []interface{}{userID}},
+
+		{"DELETE FROM friends WHERE requester_refer = ? OR receiver_refer = ?", []interface{}{userID, userID}},
+		{"DELETE FROM friend_requests WHERE requester_refer = ? OR receiver_refer = ?", []interface{}{userID, userID}},
+		{"DELETE FROM life_requests WHERE requester_refer = ? OR receiver_refer = ?", []interface{}{userRefer, userRefer}},
+		{"DELETE FROM blocks WHERE blocker_refer = ? OR blocked_refer = ?", []interface{}{userID, userID}},
+		{"DELETE FROM reports WHERE reporter_refer = ? OR reported_refer = ?", []interface{}{userID, userID}},
+		{"DELETE FROM contact_links WHERE user_refer = ? OR contact_refer = ?", []interface{}{userID, userID}},
+		{"DELETE FROM contact_imports WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM feed_items WHERE owner_refer = ? OR actor_refer = ?", []interface{}{userID, userID}},
+
+		{"DELETE FROM referral_codes WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM referral_links WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM referrals WHERE referrer_refer = ? OR invitee_refer = ?", []interface{}{userID, userID}},
+
+		{"DELETE FROM coin_transactions WHERE user_refer = ?", []interface{}{userID}},
+		{"UPDATE purchases SET user_refer = 0 WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM user_achievements WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM saves WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM user_field_revisions WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM user_search_terms WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM user_identities WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM push_tokens WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM auth_tokens WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM login_codes WHERE id IN (SELECT login_code_id FROM user_logincodes WHERE user_id = ?)", []interface{}{userID}},
+		{"DELETE FROM user_logincodes WHERE user_id = ?", []interface{}{userID}},
+		{"DELETE FROM merge_attempts WHERE target_refer = ?", []interface{}{userID}},
+		{"DELETE FROM account_deletions WHERE user_refer = ?", []interface{}{userID}},
+		{"DELETE FROM users WHERE id = ?", []interface{}{userID}},
+	}
+
+	tx := GetDatabaseSession().Begin()
+	for _, statement := range statements {
+		if result := tx.Exec(statement.query, statement.args...); result.Error != nil {
+			tx.Rollback()
+			tmpLog.Error(fmt.Sprintf("couldn't delete user '%d': %v", userID, result.Error))
+			return result.Error
+		}
+	}
+	if result := tx.Commit(); result.Error != nil {
+		return result.Error
+	}
+
+	tmpLog.Info(fmt.Sprintf("deleted user '%d'", userID))
+	return nil
+}
+
+//CleanUp deletes the accounts whose grace period is over
+func (accountDeletion AccountDeletion) CleanUp() {
+	db := GetDatabaseSession()
+
+	var accountDeletions []AccountDeletion
+	db.Where("delete_after < ?", time.Now()).Order("delete_after asc").Limit(50).Find(&accountDeletions)
+	for _, dueDeletion := range accountDeletions {
+		var user User
+		if err := user.FindByID(dueDeletion.UserRefer); err != nil {
+			// the user is already gone
+			db.Unscoped().Delete(&dueDeletion)
+			continue
+		}
+		user.DeleteAccount()
+	}
+}
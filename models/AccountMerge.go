@@ -0,0 +1,301 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"timedrop/config"
+
+	"github.com/jinzhu/gorm"
+)
+
+var CoinReasonAccountMerge = "account_merge"
+
+var (
+	MergeProofLoginCode = "login_code"
+	MergeProofIdentity  = "identity"
+)
+
+var (
+	ErrAccountProofRequired = errors.New("account_proof_required")
+	ErrCanNotMergeSameUser  = errors.New("can_not_merge_same_account")
+	ErrTooManyMergeAttempts = errors.New("too_many_merge_attempts")
+)
+
+//MergeProof is what the client sends to prove it owns the account it wants
+//to merge into, either an email with a login code or an identity token
+type MergeProof struct {
+	Email      string `json:"email"`
+	VerifyCode string `json:"verifyCode"`
+	Provider   string `json:"provider"`
+	Token      string `json:"token"`
+}
+
+//MergePreview tells the player what moves to the target account
+type MergePreview struct {
+	Source       User `json:"source"`
+	Target       User `json:"target"`
+	Coins        int  `json:"coins"`
+	Friends      int  `json:"friends"`
+	Games        int  `json:"games"`
+	Achievements int  `json:"achievements"`
+	LifeRequests int  `json:"lifeRequests"`
+}
+
+//AccountMerge is the audit record of a merge, the source user is deleted
+//afterwards
+type AccountMerge struct {
+	BaseModel
+
+	SourceRefer uint   `json:"sourceId" sql:"index"`
+	TargetRefer uint   `json:"targetId" sql:"index"`
+	Proof       string `json:"proof"`
+	// Summary is the preview at the time of the merge as JSON
+	Summary string `json:"summary" sql:"type:text"`
+}
+
+//MergeAttempt is a merge proof with a wrong login code, the codes are short
+//so the attempts are limited per target account and per IP
+type MergeAttempt struct {
+	BaseModel
+
+	TargetRefer uint   `json:"targetId" sql:"index"`
+	IP          string `json:"ip" sql:"index"`
+}
+
+//FindMergeTarget returns the user the proof belongs to, a login code is only
+//used up when consume is set so the merge can be previewed first
+func FindMergeTarget(proof MergeProof, ip string, consume bool) (target User, proofType string, err error) {
+	if proof.Provider != "" && proof.Token != "" {
+		identity, err := VerifyIdentity(proof.Provider, proof.Token)
+		if err != nil {
+			return User{}, "", err
+		}
+		if target, err = FindUserByIdentity(identity); err != nil {
+			return User{}, "", ErrAccountProofRequired
+		}
+		return target, MergeProofIdentity + ":" + identity.Provider, nil
+	}
+
+	if proof.Email != "" && proof.VerifyCode != "" {
+		target.FindByEmail(proof.Email)
+		if err := checkMergeAttempts(target.ID, ip); err != nil {
+			return User{}, "", err
+		}
+
+		maxAge := time.Duration(config.Cfg.AccountSettings.LoginCodeMinutes) * time.Minute
+		valid := target.ID != 0 && target.HasLoginCode(proof.VerifyCode, maxAge)
+		if valid && consume {
+			valid = target.ConsumeLoginCode(proof.VerifyCode, maxAge)
+		}
+		if !valid {
+			GetDatabaseSession().Create(&MergeAttempt{TargetRefer: target.ID, IP: ip})
+			return User{}, "", ErrAccountProofRequired
+		}
+		return target, MergeProofLoginCode, nil
+	}
+
+	return User{}, "", ErrAccountProofRequired
+}
+
+//checkMergeAttempts returns an error once the wrong login codes for the
+//target or from the IP reached the limit
+func checkMergeAttempts(targetID uint, ip string) error {
+	settings := config.Cfg.AccountSettings
+	db := GetDatabaseSession()
+	since := time.Now().Add(-time.Duration(settings.MergeAttemptWindowMinutes) * time.Minute)
+
+	if settings.MaxMergeAttemptsPerTarget > 0 && targetID != 0 {
+		var attempts int
+		db.Model(&MergeAttempt{}).Where("target_refer = ? AND created_at > ?", targetID, since).Count(&attempts)
+		if attempts >= settings.MaxMergeAttemptsPerTarget {
+			userLogger.Info(fmt.Sprintf("merge attempts for user '%d' reached the limit", targetID))
+			return ErrTooManyMergeAttempts
+		}
+	}
+	if settings.MaxMergeAttemptsPerIP > 0 && ip != "" {
+		var attempts int
+		db.Model(&MergeAttempt{}).Where("ip = ? AND created_at > ?", ip, since).Count(&attempts)
+		if attempts >= settings.MaxMergeAttemptsPerIP {
+			userLogger.Info(fmt.Sprintf("merge attempts from '%s' reached the limit", ip))
+			return ErrTooManyMergeAttempts
+		}
+	}
+	return nil
+}
+
+//CleanUp removes the attempts which no longer count for the limits
+func (mergeAttempt MergeAttempt) CleanUp() {
+	db := GetDatabaseSession()
+	since := time.Now().Add(-time.Duration(config.Cfg.AccountSettings.MergeAttemptWindowMinutes) * time.Minute)
+	db.Unscoped().Where("created_at < ?", since).Delete(MergeAttempt{})
+}
+
+//PreviewMerge counts what would move from the user to the target
+func (user *User) PreviewMerge(target User) (MergePreview, error) {
+	if user.ID == target.ID {
+		return MergePreview{}, ErrCanNotMergeSameUser
+	}
+
+	db := GetDatabaseSession()
+	preview := MergePreview{
+		Source: *user,
+		Target: target,
+		Coins:  user.Coins,
+	}
+
+	db.Raw("SELECT COUNT(*) FROM ("+
+		"SELECT receiver_refer AS friend FROM friends WHERE requester_refer = ? UNION "+
+		"SELECT requester_refer AS friend FROM friends WHERE receiver_refer = ?) f "+
+		"WHERE f.friend != ? AND f.friend NOT IN ("+
+		"SELECT receiver_refer FROM friends WHERE requester_refer = ? UNION "+
+		"SELECT requester_refer FROM friends WHERE receiver_refer = ?)",
+		user.ID, user.ID, target.ID, target.ID, target.ID).Row().Scan(&preview.Friends)
+
+	db.Model(&Game{}).Where("(creator_refer = ? AND opponent_refer != ?) OR (opponent_refer = ? AND creator_refer != ?)",
+		user.ID, target.ID, user.ID, target.ID).Count(&preview.Games)
+
+	db.Raw("SELECT COUNT(*) FROM user_achievements WHERE user_refer = ? AND deleted_at IS NULL AND achievement_key NOT IN ("+
+		"SELECT achievement_key FROM user_achievements WHERE user_refer = ? AND deleted_at IS NULL)",
+		user.ID, target.ID).Row().Scan(&preview.Achievements)
+
+	userID := strconv.Itoa(int(user.ID))
+	db.Model(&LifeRequest{}).Where("requester_refer = ? OR receiver_refer = ?", userID, userID).Count(&preview.LifeRequests)
+
+	return preview, nil
+}
+
+//MergeInto moves the progress of the user to the target within one database
+//transaction and deletes the user. Friends, games, blocks, reports, life
+//requests, unlocked achievements, identities and coins are moved.
+func (user *User) MergeInto(target *User, proof string) (AccountMerge, error) {
+	tmpLog := userLogger.New("func", "MergeInto")
+
+	preview, err := user.PreviewMerge(*target)
+	if err != nil {
+		return AccountMerge{}, err
+	}
+
+	summary, err := json.Marshal(struct {
+		Coins        int `json:"coins"`
+		Friends      int `json:"friends"`
+		Games        int `json:"games"`
+		Achievements int `json:"achievements"`
+		LifeRequests int `json:"lifeRequests"`
+	}{preview.Coins, preview.Friends, preview.Games, preview.Achievements, preview.LifeRequests})
+	if err != nil {
+		return AccountMerge{}, err
+	}
+
+	tx := GetDatabaseSession().Begin()
+	if err := user.mergeInto(tx, target); err != nil {
+		tx.Rollback()
+		tmpLog.Error(fmt.Sprintf("couldn't merge user '%d' into '%d': %v", user.ID, target.ID, err))
+		return AccountMerge{}, err
+	}
+
+	accountMerge := AccountMerge{
+		SourceRefer: user.ID,
+		TargetRefer: target.ID,
+		Proof:       proof,
+		Summary:     string(summary),
+	}
+	if result := tx.Create(&accountMerge); result.Error != nil {
+		tx.Rollback()
+		return AccountMerge{}, result.Error
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return AccountMerge{}, result.Error
+	}
+
+	target.FindByID(target.ID)
+	if err := target.UpdateSearchIndex(); err != nil {
+		tmpLog.Error(fmt.Sprintf("couldn't update search index of user '%d': %v", target.ID, err))
+	}
+
+	tmpLog.Info(fmt.Sprintf("merged user '%d' into '%d' (%s): %s", user.ID, target.ID, proof, summary))
+	return accountMerge, nil
+}
+
+//mergeInto runs the statements of a merge on the transaction
+func (user *User) mergeInto(tx *gorm.DB, target *User) error {
+	sourceID, targetID := user.ID, target.ID
+	sourceRefer, targetRefer := strconv.Itoa(int(sourceID)), strconv.Itoa(int(targetID))
+
+	statements := []struct {
+		query string
+		args  []interface{}
+	}{
+		// friendships the target already has or with the target itself are dropped
+		{"UPDATE IGNORE friends SET requester_refer = ? WHERE requester_refer = ? AND receiver_refer != ?", []interface{}{targetID, sourceID, targetID}},
+		{"UPDATE IGNORE friends SET receiver_refer = ? WHERE receiver_refer = ? AND requester_refer != ?", []interface{}{targetID, sourceID, targetID}},
+		{"DELETE FROM friends WHERE requester_refer = ? OR receiver_refer = ?", []interface{}{sourceID, sourceID}},
+		{"UPDATE IGNORE friend_requests SET requester_refer = ? WHERE requester_refer = ? AND receiver_refer != ?", []interface{}{targetID, sourceID, targetID}},
+		{"UPDATE IGNORE friend_requests SET receiver_refer = ? WHERE receiver_refer = ? AND requester_refer != ?", []interface{}{targetID, sourceID, targetID}},
+		{"DELETE FROM friend_requests WHERE requester_refer = ? OR receiver_refer = ?", []interface{}{sourceID, sourceID}},
+
+		// games the two accounts played against each other would become games
+		// against itself
+		{"DELETE FROM games WHERE (creator_refer = ? AND opponent_refer = ?) OR (creator_refer = ? AND opponent_refer = ?)",
+			[]interface{}{sourceID, targetID, targetID, sourceID}},
+		{"UPDATE games SET creator_refer = ? WHERE creator_refer = ?", []interface{}{targetID, sourceID}},
+		{"UPDATE games SET opponent_refer = ? WHERE opponent_refer = ?", []interface{}{targetID, sourceID}},
+		{"UPDATE games SET won_refer = ? WHERE won_refer = ?", []interface{}{targetID, sourceID}},
+		{"UPDATE games SET lost_refer = ? WHERE lost_refer = ?", []interface{}{targetID, sourceID}},
+		{"UPDATE users SET games_played_count = games_played_count + ?, games_won_count = games_won_count + ? WHERE id = ?",
+			[]interface{}{user.GamesPlayedCount, user.GamesWonCount, targetID}},
+
+		// blocks by and against the source carry over, a merge must not lift them
+		{"UPDATE IGNORE blocks SET blocker_refer = ? WHERE blocker_refer = ? AND blocked_refer != ?", []interface{}{targetID, sourceID, targetID}},
+		{"UPDATE IGNORE blocks SET blocked_refer = ? WHERE blocked_refer = ? AND blocker_refer != ?", []interface{}{targetID, sourceID, targetID}},
+		{"DELETE FROM blocks WHERE blocker_refer = ? OR blocked_refer = ?", []interface{}{sourceID, sourceID}},
+		{"UPDATE reports SET reporter_refer = ? WHERE reporter_refer = ?", []interface{}{targetID, sourceID}},
+		{"UPDATE reports SET reported_refer = ? WHERE reported_refer = ?", []interface{}{targetID, sourceID}},
+
+		{"UPDATE life_requests SET requester_refer = ? WHERE requester_refer = ?", []interface{}{targetRefer, sourceRefer}},
+		{"UPDATE life_requests SET receiver_refer = ? WHERE receiver_refer = ?", []interface{}{targetRefer, sourceRefer}},
+
+		{"UPDATE IGNORE user_achievements SET user_refer = ? WHERE user_refer = ?", []interface{}{targetID, sourceID}},
+		{"DELETE FROM user_achievements WHERE user_refer = ?", []interface{}{sourceID}},
+
+		// the target keeps its own identity when both have one of a provider
+		{"UPDATE user_identities SET user_refer = ? WHERE user_refer = ? AND provider NOT IN (" +
+			"SELECT provider FROM (SELECT provider FROM user_identities WHERE user_refer = ?) t)", []interface{}{targetID, sourceID, targetID}},
+		{"DELETE FROM user_identities WHERE user_refer = ?", []interface{}{sourceID}},
+
+		{"DELETE FROM auth_tokens WHERE user_refer = ?", []interface{}{sourceID}},
+		{"DELETE FROM push_tokens WHERE user_refer = ?", []interface{}{sourceID}},
+		{"DELETE FROM user_search_terms WHERE user_refer = ?", []interface{}{sourceID}},
+		{"DELETE FROM feed_items WHERE owner_refer = ? OR actor_refer = ?", []interface{}{sourceID, sourceID}},
+	}
+
+	for _, statement := range statements {
+		if result := tx.Exec(statement.query, statement.args...); result.Error != nil {
+			return result.Error
+		}
+	}
+
+	// the coins are moved through the ledger, the balance is read locked
+	var lockedUser User
+	if result := tx.Set("gorm:query_option", "FOR UPDATE").First(&lockedUser, sourceID); result.Error != nil {
+		return result.Error
+	}
+	if coins := lockedUser.Coins; coins > 0 {
+		idempotencyKey := fmt.Sprintf("merge:%d:%d", sourceID, targetID)
+		if _, err := user.bookCoinTransaction(tx, -coins, CoinReasonAccountMerge, idempotencyKey); err != nil {
+			return err
+		}
+		if _, err := target.bookCoinTransaction(tx, coins, CoinReasonAccountMerge, idempotencyKey); err != nil {
+			return err
+		}
+	}
+
+	if result := tx.Delete(user); result.Error != nil {
+		return result.Error
+	}
+	return nil
+}
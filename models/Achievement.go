@@ -0,0 +1,244 @@
+package models
+
+import (
+	"fmt"
+	"time"
+
+	"timedrop/config"
+)
+
+var CoinReasonAchievement = "achievement"
+
+//UserAchievement marks an achievement as unlocked for a user
+type UserAchievement struct {
+	BaseModel
+
+	UserRefer      uint      `json:"userId" gorm:"unique_index:idx_user_achievement"`
+	AchievementKey string    `json:"key" gorm:"unique_index:idx_user_achievement"`
+	UnlockedAt     time.Time `json:"unlockedAt"`
+}
+
+//AchievementProgress is an achievement definition with the progress of a user
+type AchievementProgress struct {
+	Key         string     `json:"key"`
+	Name        string     `json:"name"`
+	Type        string     `json:"type"`
+	Target      int        `json:"target"`
+	Progress    int        `json:"progress"`
+	RewardCoins int        `json:"rewardCoins"`
+	RewardLives int        `json:"rewardLives"`
+	Unlocked    bool       `json:"unlocked"`
+	UnlockedAt  *time.Time `json:"unlockedAt"`
+}
+
+//achievementUnlockedListeners are called after an achievement got unlocked
+var achievementUnlockedListeners []func(User, config.AchievementDefinition)
+
+//OnAchievementUnlocked registers a listener for unlocked achievements
+func OnAchievementUnlocked(listener func(User, config.AchievementDefinition)) {
+	achievementUnlockedListeners = append(achievementUnlockedListeners, listener)
+}
+
+func emitAchievementUnlocked(user User, definition config.AchievementDefinition) {
+	for _, listener := range achievementUnlockedListeners {
+		listener(user, definition)
+	}
+}
+
+//achievementStats are the values the achievement rules are evaluated against
+type achievementStats struct {
+	GamesPlayed   int
+	GamesWon      int
+	LevelOrder    int
+	FriendsBeaten int
+	Friends       int
+}
+
+func init() {
+	OnGameCompleted(func(game Game) {
+		evaluateAchievementsOf(game.CreatorRefer, game.OpponentRefer)
+	})
+	OnLevelChange(func(change LevelChange) {
+		evaluateAchievementsOf(change.User.ID)
+	})
+	OnFriendAdded(func(friend Friend) {
+		evaluateAchievementsOf(friend.RequesterRefer, friend.ReceiverRefer)
+	})
+}
+
+//value returns the stat an achievement type is counted by
+func (stats achievementStats) value(achievementType string) int {
+	switch achievementType {
+	case config.AchievementTypeGamesPlayed:
+		return stats.GamesPlayed
+	case config.AchievementTypeGamesWon:
+		return stats.GamesWon
+	case config.AchievementTypeLevelReached:
+		return stats.LevelOrder
+	case config.AchievementTypeFriendsBeaten:
+		return stats.FriendsBeaten
+	case config.AchievementTypeFriends:
+		return stats.Friends
+	}
+	return 0
+}
+
+//reachedAchievements returns the definitions whose target is met and which
+//are not unlocked yet
+func reachedAchievements(definitions []config.AchievementDefinition, stats achievementStats, unlocked map[string]UserAchievement) []config.AchievementDefinition {
+	var reached []config.AchievementDefinition
+	for _, definition := range definitions {
+		if _, ok := unlocked[definition.Key]; ok {
+			continue
+		}
+		if stats.value(definition.Type) >= definition.Target {
+			reached = append(reached, definition)
+		}
+	}
+	return reached
+}
+
+//achievementStats collects the current stats of the user
+func (user *User) achievementStats() achievementStats {
+	db := GetDatabaseSession()
+
+	stats := achievementStats{
+		GamesPlayed: user.GamesPlayedCount,
+		GamesWon:    user.GamesWonCount,
+	}
+
+	// the top level counts, a later demotion doesn't take an achievement away
+	levelID := user.TopLevelRefer
+	if levelID == 0 {
+		levelID = user.LevelRefer
+	}
+	if levelID != 0 {
+		var level Level
+		if err := level.FindByID(levelID); err == nil {
+			stats.LevelOrder = level.Order
+		}
+	}
+
+	db.Model(&Friend{}).Where("requester_refer = ? OR receiver_refer = ?", user.ID, user.ID).Count(&stats.Friends)
+
+	db.Raw("SELECT COUNT(*) FROM games g JOIN friends f ON "+
+		"((f.requester_refer = g.won_refer AND f.receiver_refer = g.lost_refer) OR (f.receiver_refer = g.won_refer AND f.requester_refer = g.lost_refer)) "+
+		"WHERE g.won_refer = ? AND g.completed = 1 AND g.deleted_at IS NULL", user.ID).Row().Scan(&stats.FriendsBeaten)
+
+	return stats
+}
+
+//unlockedAchievements returns the unlocked achievements of the user by key
+func (user *User) unlockedAchievements() (map[string]UserAchievement, error) {
+	db := GetDatabaseSession()
+
+	var userAchievements []UserAchievement
+	if result := db.Where("user_refer = ?", user.ID).Find(&userAchievements); result.Error != nil {
+		return nil, result.Error
+	}
+
+	unlocked := make(map[string]UserAchievement)
+	for _, userAchievement := range userAchievements {
+		unlocked[userAchievement.AchievementKey] = userAchievement
+	}
+	return unlocked, nil
+}
+
+//GetAchievements lists all configured achievements with the users progress
+func (user *User) GetAchievements() ([]AchievementProgress, error) {
+	unlocked, err := user.unlockedAchievements()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := user.achievementStats()
+
+	achievements := []AchievementProgress{}
+	for _, definition := range config.Achievements {
+		progress := stats.value(definition.Type)
+		if progress > definition.Target {
+			progress = definition.Target
+		}
+
+		achievement := AchievementProgress{
+			Key:         definition.Key,
+			Name:        definition.Name,
+			Type:        definition.Type,
+			Target:      definition.Target,
+			Progress:    progress,
+			RewardCoins: definition.RewardCoins,
+			RewardLives: definition.RewardLives,
+		}
+		if userAchievement, ok := unlocked[definition.Key]; ok {
+			unlockedAt := userAchievement.UnlockedAt
+			achievement.Unlocked = true
+			achievement.UnlockedAt = &unlockedAt
+			achievement.Progress = definition.Target
+		}
+		achievements = append(achievements, achievement)
+	}
+
+	return achievements, nil
+}
+
+//EvaluateAchievements unlocks and rewards every achievement the user reached
+func (user *User) EvaluateAchievements() ([]UserAchievement, error) {
+	tmpLog := userLogger.New("func", "EvaluateAchievements")
+	db := GetDatabaseSession()
+
+	unlocked, err := user.unlockedAchievements()
+	if err != nil {
+		return nil, err
+	}
+
+	var newAchievements []UserAchievement
+	for _, definition := range reachedAchievements(config.Achievements, user.achievementStats(), unlocked) {
+		userAchievement := UserAchievement{
+			UserRefer:      user.ID,
+			AchievementKey: definition.Key,
+			UnlockedAt:     time.Now(),
+		}
+
+		// the unique index makes sure concurrent evaluations reward only once
+		if result := db.Create(&userAchievement); result.Error != nil {
+			continue
+		}
+		tmpLog.Info(fmt.Sprintf("user '%d' unlocked achievement '%s'", user.ID, definition.Key))
+
+		if definition.RewardCoins > 0 {
+			idempotencyKey := CoinReasonAchievement + ":" + definition.Key
+			if _, err := user.GrantCoins(definition.RewardCoins, CoinReasonAchievement, idempotencyKey); err != nil {
+				tmpLog.Error(fmt.Sprintf("couldn't grant achievement coins to user '%d': %v", user.ID, err))
+			}
+		}
+		if definition.RewardLives > 0 {
+			if err := user.AddLives(definition.RewardLives); err != nil {
+				tmpLog.Error(fmt.Sprintf("couldn't grant achievement lives to user '%d': %v", user.ID, err))
+			}
+		}
+
+		var pushNotification PushNotification
+		go pushNotification.SendAchievementUnlockedPush(*user, definition.Name)
+
+		emitAchievementUnlocked(*user, definition)
+
+		newAchievements = append(newAchievements, userAchievement)
+	}
+
+	return newAchievements, nil
+}
+
+//evaluateAchievementsOf loads the given users and evaluates their achievements
+func evaluateAchievementsOf(userIDs ...uint) {
+	for _, userID := range userIDs {
+		if userID == 0 {
+			continue
+		}
+
+		var user User
+		if err := user.FindByID(userID); err != nil || user.IsBot {
+			continue
+		}
+		user.EvaluateAchievements()
+	}
+}
@@ -0,0 +1,194 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ReportReasonCheating      = "cheating"
+	ReportReasonOffensiveName = "offensive_name"
+	ReportReasonHarassment    = "harassment"
+	ReportReasonSpam          = "spam"
+	ReportReasonOther         = "other"
+
+	ReportStateOpen     = "open"
+	ReportStateResolved = "resolved"
+)
+
+//ReportReasons are the reason codes a report can be filed with
+var ReportReasons = []string{
+	ReportReasonCheating,
+	ReportReasonOffensiveName,
+	ReportReasonHarassment,
+	ReportReasonSpam,
+	ReportReasonOther,
+}
+
+var (
+	ErrUserBlocked         = errors.New("user_blocked")
+	ErrCanNotBlockYourself = errors.New("can_not_block_yourself")
+	ErrReportReasonUnknown = errors.New("report_reason_unknown")
+	ErrReportDuplicate     = errors.New("report_duplicate")
+	ErrReportNotFound      = errors.New("report_not_found")
+)
+
+//Block hides two users from each other, it works in both directions but only
+//the blocker can lift it
+type Block struct {
+	BaseModel
+
+	BlockerRefer uint `json:"blockerId" gorm:"unique_index:idx_block_ids"`
+	BlockedRefer uint `json:"blockedId" gorm:"unique_index:idx_block_ids"`
+
+	Blocked User `json:"blocked" gorm:"-"`
+}
+
+//Report is a complaint about a user waiting for moderation
+type Report struct {
+	BaseModel
+
+	ReporterRefer uint       `json:"reporterId" sql:"index"`
+	ReportedRefer uint       `json:"reportedId" sql:"index"`
+	GameRefer     uint       `json:"gameId"`
+	Reason        string     `json:"reason"`
+	Comment       string     `json:"comment" sql:"type:text"`
+	State         string     `json:"state" sql:"index"`
+	ResolvedAt    *time.Time `json:"resolvedAt"`
+	Resolution    string     `json:"resolution" sql:"type:text"`
+}
+
+//IsBlockedBetween checks if one of the users blocked the other
+func (block Block) IsBlockedBetween(userID, otherID interface{}) bool {
+	db := GetDatabaseSession()
+	sqlQuery := "(blocker_refer = ? AND blocked_refer = ?) OR (blocker_refer = ? AND blocked_refer = ?)"
+
+	var count int
+	db.Model(&Block{}).Where(sqlQuery, userID, otherID, otherID, userID).Count(&count)
+	return count > 0
+}
+
+//FindByBlocker lists the users blocked by the user
+func (block Block) FindByBlocker(userID interface{}) (blocks []Block, err error) {
+	db := GetDatabaseSession()
+	result := db.Where("blocker_refer = ?", userID).Order("id desc").Find(&blocks)
+	return blocks, result.Error
+}
+
+//BlockUser blocks the other user and ends everything between them:
+//friendship, friend requests, open games, life requests and feed items
+func (user *User) BlockUser(blockedID uint) (Block, error) {
+	tmpLog := userLogger.New("func", "BlockUser")
+	db := GetDatabaseSession()
+
+	if blockedID == user.ID {
+		return Block{}, ErrCanNotBlockYourself
+	}
+
+	var blocked User
+	if err := blocked.FindByID(blockedID); err != nil {
+		return Block{}, err
+	}
+
+	var block Block
+	db.Where("blocker_refer = ? AND blocked_refer = ?", user.ID, blockedID).First(&block)
+	if block.ID == 0 {
+		block = Block{
+			BlockerRefer: user.ID,
+			BlockedRefer: blockedID,
+		}
+		if result := db.Create(&block); result.Error != nil {
+			return Block{}, result.Error
+		}
+	}
+
+	var friend Friend
+	friend.Delete(user.ID, blockedID)
+
+	// open games between the two end with the block
+	db.Unscoped().Where("((creator_refer = ? AND opponent_refer = ?) OR (creator_refer = ? AND opponent_refer = ?)) AND completed != 1",
+		user.ID, blockedID, blockedID, user.ID).Delete(&Game{})
+
+	sqlQuery := "(requester_refer = ? AND receiver_refer = ?) OR (requester_refer = ? AND receiver_refer = ?)"
+	// declined requests stay, they keep the cooldown after an unblock
+	db.Unscoped().Where("("+sqlQuery+") AND status = ?", user.ID, blockedID, blockedID, user.ID, FriendRequestStatusPending).Delete(FriendRequest{})
+	db.Exec("DELETE FROM life_requests WHERE ("+sqlQuery+") AND approved = 'false'", user.ID, blockedID, blockedID, user.ID)
+	db.Exec("DELETE FROM feed_items WHERE (owner_refer = ? AND actor_refer = ?) OR (owner_refer = ? AND actor_refer = ?)", user.ID, blockedID, blockedID, user.ID)
+
+	tmpLog.Info(fmt.Sprintf("user '%d' blocked user '%d'", user.ID, blockedID))
+
+	block.Blocked = blocked
+	return block, nil
+}
+
+//UnblockUser lifts a block of the user
+func (user *User) UnblockUser(blockedID interface{}) error {
+	db := GetDatabaseSession()
+	return db.Unscoped().Where("blocker_refer = ? AND blocked_refer = ?", user.ID, blockedID).Delete(Block{}).Error
+}
+
+//ReportUser files a report for moderation, there can only be one open
+//report per user and reason
+func (user *User) ReportUser(reportedID, gameID uint, reason, comment string) (Report, error) {
+	db := GetDatabaseSession()
+
+	knownReason := false
+	for _, reportReason := range ReportReasons {
+		if reason == reportReason {
+			knownReason = true
+		}
+	}
+	if !knownReason {
+		return Report{}, ErrReportReasonUnknown
+	}
+
+	var reported User
+	if err := reported.FindByID(reportedID); err != nil {
+		return Report{}, err
+	}
+
+	var count int
+	db.Model(&Report{}).Where("reporter_refer = ? AND reported_refer = ? AND reason = ? AND state = ?",
+		user.ID, reportedID, reason, ReportStateOpen).Count(&count)
+	if count > 0 {
+		return Report{}, ErrReportDuplicate
+	}
+
+	report := Report{
+		ReporterRefer: user.ID,
+		ReportedRefer: reportedID,
+		GameRefer:     gameID,
+		Reason:        reason,
+		Comment:       comment,
+		State:         ReportStateOpen,
+	}
+	if result := db.Create(&report); result.Error != nil {
+		return Report{}, result.Error
+	}
+
+	return report, nil
+}
+
+//FindByState lists reports for moderation, oldest first
+func (report Report) FindByState(state string, limit int) (reports []Report, err error) {
+	db := GetDatabaseSession()
+	result := db.Where("state = ?", state).Order("id asc").Limit(limit).Find(&reports)
+	return reports, result.Error
+}
+
+//Resolve closes a report with the decision of the moderator
+func (report *Report) Resolve(reportID interface{}, resolution string) error {
+	db := GetDatabaseSession()
+
+	if result := db.First(&report, reportID); result.Error != nil {
+		return ErrReportNotFound
+	}
+
+	now := time.Now()
+	report.State = ReportStateResolved
+	report.ResolvedAt = &now
+	report.Resolution = resolution
+
+	return db.Save(&report).Error
+}
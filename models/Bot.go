@@ -0,0 +1,150 @@
+package models
+
+import (
+	"fmt"
+	"math/rand"
+	"time"
+
+	"timedrop/config"
+)
+
+// scores of the latest games are enough for a realistic distribution
+const botScoreSampleLimit = 200
+
+//EnsureBots creates the configured bot users which don't exist yet
+func EnsureBots() {
+	tmpLog := userLogger.New("func", "EnsureBots")
+	settings := config.Cfg.BotSettings
+	if !settings.Enabled {
+		return
+	}
+
+	for _, username := range settings.Usernames {
+		var bot User
+		bot.FindByUsername(username)
+		if bot.ID != 0 {
+			if !bot.IsBot {
+				tmpLog.Error(fmt.Sprintf("bot username '%s' is taken by a real user", username))
+			}
+			continue
+		}
+
+		bot = User{
+			Username: username,
+			IsBot:    true,
+		}
+		if err := bot.Save(); err != nil {
+			tmpLog.Error(fmt.Sprintf("couldn't create bot '%s': %v", username, err))
+		}
+	}
+}
+
+//findBots returns all bot users
+func findBots() []User {
+	db := GetDatabaseSession()
+
+	var bots []User
+	db.Where("is_bot = 1").Find(&bots)
+	return bots
+}
+
+//botScore draws a score from the samples of real games, without samples it
+//varies the score of the creator by up to 20 percent
+func botScore(samples []int, creatorScore int, rnd *rand.Rand) int {
+	if len(samples) > 0 {
+		return samples[rnd.Intn(len(samples))]
+	}
+
+	spread := creatorScore / 5
+	if spread <= 0 {
+		return creatorScore + 1
+	}
+	score := creatorScore - spread + rnd.Intn(2*spread+1)
+	if score <= 0 {
+		score = 1
+	}
+	return score
+}
+
+//scoreSamples returns recent scores of human players on the map and mode,
+//limited to the level if enough games were played there
+func scoreSamples(game Game, minSamples int) []int {
+	db := GetDatabaseSession()
+
+	query := func(levelFilter bool) []int {
+		sqlQuery := "SELECT score_creator FROM games WHERE map_id = ? AND type = ? AND completed = 1 AND against_bot = 0 AND score_creator > 0"
+		args := []interface{}{game.MapID, game.Type}
+		if levelFilter {
+			sqlQuery += " AND level_refer = ?"
+			args = append(args, game.LevelRefer)
+		}
+		sqlQuery += fmt.Sprintf(" ORDER BY id DESC LIMIT %d", botScoreSampleLimit)
+
+		var samples []int
+		rows, err := db.Raw(sqlQuery, args...).Rows()
+		if err != nil {
+			return samples
+		}
+		defer rows.Close()
+		for rows.Next() {
+			var score int
+			rows.Scan(&score)
+			samples = append(samples, score)
+		}
+		return samples
+	}
+
+	if samples := query(true); len(samples) >= minSamples {
+		return samples
+	}
+	if samples := query(false); len(samples) >= minSamples {
+		return samples
+	}
+	return nil
+}
+
+//assignBots lets a bot play the games which waited too long for a human
+//opponent. Bot games are flagged with AgainstBot and bots don't keep
+//statistics or show up in toplists and search.
+func assignBots(now time.Time) {
+	tmpLog := userLogger.New("func", "assignBots")
+	settings := config.Cfg.BotSettings
+	if !settings.Enabled {
+		return
+	}
+
+	bots := findBots()
+	if len(bots) == 0 {
+		return
+	}
+
+	db := GetDatabaseSession()
+	deadline := now.Add(-time.Duration(settings.TakeOverMinutes) * time.Minute)
+
+	var games []Game
+	sqlQuery := "opponent_refer = 0 AND completed = 0 AND from_friend_request = 0 AND state_creator = ? AND updated_at <= ?"
+	db.Where(sqlQuery, GameStateCompleted, deadline).Find(&games)
+
+	rnd := rand.New(rand.NewSource(now.UnixNano()))
+	for _, game := range games {
+		bot := bots[rnd.Intn(len(bots))]
+
+		// a human might have been matched meanwhile
+		result := db.Exec("UPDATE games SET opponent_refer = ?, against_bot = 1 WHERE id = ? AND opponent_refer = 0 AND completed = 0",
+			bot.ID, game.ID)
+		if result.Error != nil || result.RowsAffected == 0 {
+			continue
+		}
+
+		game.OpponentRefer = bot.ID
+		game.AgainstBot = true
+		game.StateOpponent = GameStateCompleted
+		game.ScoreOpponent = botScore(scoreSamples(game, settings.MinScoreSamples), game.ScoreCreator, rnd)
+
+		if err := game.Complete(); err != nil {
+			tmpLog.Error(fmt.Sprintf("bot '%d' couldn't complete game '%d': %v", bot.ID, game.ID, err))
+			continue
+		}
+		tmpLog.Debug(fmt.Sprintf("bot '%d' took game '%d' with score %d", bot.ID, game.ID, game.ScoreOpponent))
+	}
+}
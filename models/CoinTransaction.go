@@ -0,0 +1,123 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	CoinReasonLevelPromotion = "level_promotion"
+	CoinReasonSupport        = "support"
+	CoinReasonSpend          = "spend"
+)
+
+var (
+	ErrInvalidCoinAmount       = errors.New("invalid_coin_amount")
+	ErrInsufficientCoins       = errors.New("insufficient_coins")
+	ErrCoinTransactionConflict = errors.New("coin_transaction_conflict")
+)
+
+//CoinTransaction is an append-only entry of the coin ledger. User.Coins is
+//always the Balance of the latest transaction of the user.
+type CoinTransaction struct {
+	BaseModel
+
+	UserRefer      uint   `json:"userId" gorm:"unique_index:idx_coin_tx_key"`
+	IdempotencyKey string `json:"idempotencyKey" gorm:"unique_index:idx_coin_tx_key"`
+	Amount         int    `json:"amount"`
+	Balance        int    `json:"balance"`
+	Reason         string `json:"reason"`
+}
+
+//FindByUserID lists the transactions of a user, newest first
+func (coinTransaction CoinTransaction) FindByUserID(userID interface{}, limit int) (coinTransactions []CoinTransaction, err error) {
+	db := GetDatabaseSession()
+	result := db.Where("user_refer = ?", userID).Order("id desc").Limit(limit).Find(&coinTransactions)
+	return coinTransactions, result.Error
+}
+
+//GrantCoins adds coins to the users balance
+func (user *User) GrantCoins(amount int, reason, idempotencyKey string) (CoinTransaction, error) {
+	if amount <= 0 {
+		return CoinTransaction{}, ErrInvalidCoinAmount
+	}
+	return user.addCoinTransaction(amount, reason, idempotencyKey)
+}
+
+//SpendCoins removes coins from the users balance if there are enough left
+func (user *User) SpendCoins(amount int, reason, idempotencyKey string) (CoinTransaction, error) {
+	if amount <= 0 {
+		return CoinTransaction{}, ErrInvalidCoinAmount
+	}
+	return user.addCoinTransaction(-amount, reason, idempotencyKey)
+}
+
+//addCoinTransaction books the amount within its own database transaction
+func (user *User) addCoinTransaction(amount int, reason, idempotencyKey string) (CoinTransaction, error) {
+	tx := GetDatabaseSession().Begin()
+
+	coinTransaction, err := user.bookCoinTransaction(tx, amount, reason, idempotencyKey)
+	if err != nil {
+		tx.Rollback()
+		return CoinTransaction{}, err
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return CoinTransaction{}, result.Error
+	}
+
+	return coinTransaction, nil
+}
+
+//bookCoinTransaction books the amount within the given database transaction,
+//the caller has to commit or roll it back. Sending the same idempotency key
+//twice returns the already booked transaction.
+func (user *User) bookCoinTransaction(tx *gorm.DB, amount int, reason, idempotencyKey string) (CoinTransaction, error) {
+	tmpLog := userLogger.New("func", "bookCoinTransaction")
+
+	if idempotencyKey == "" || reason == "" {
+		return CoinTransaction{}, errors.New("invalid_coin_transaction")
+	}
+
+	var lockedUser User
+	if result := tx.Set("gorm:query_option", "FOR UPDATE").First(&lockedUser, user.ID); result.Error != nil {
+		return CoinTransaction{}, result.Error
+	}
+
+	var existing CoinTransaction
+	tx.Where("user_refer = ? AND idempotency_key = ?", user.ID, idempotencyKey).First(&existing)
+	if existing.ID != 0 {
+		if existing.Amount != amount || existing.Reason != reason {
+			return CoinTransaction{}, ErrCoinTransactionConflict
+		}
+		user.Coins = lockedUser.Coins
+		return existing, nil
+	}
+
+	balance := lockedUser.Coins + amount
+	if balance < 0 {
+		return CoinTransaction{}, ErrInsufficientCoins
+	}
+
+	coinTransaction := CoinTransaction{
+		UserRefer:      user.ID,
+		IdempotencyKey: idempotencyKey,
+		Amount:         amount,
+		Balance:        balance,
+		Reason:         reason,
+	}
+	if result := tx.Create(&coinTransaction); result.Error != nil {
+		return CoinTransaction{}, result.Error
+	}
+
+	if result := tx.Exec("UPDATE users SET coins = ? WHERE id = ?", balance, user.ID); result.Error != nil {
+		return CoinTransaction{}, result.Error
+	}
+
+	tmpLog.Debug(fmt.Sprintf("booked %d coins for user '%d' (%s), balance %d", amount, user.ID, reason, balance))
+	user.Coins = balance
+
+	return coinTransaction, nil
+}
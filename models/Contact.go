@@ -0,0 +1,157 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"timedrop/config"
+)
+
+var ContactSourceAddressBook = "address_book"
+
+var (
+	ErrTooManyContacts    = errors.New("too_many_contacts")
+	ErrContactImportQuota = errors.New("contact_import_quota")
+)
+
+//ContactImport counts the hashes of an import for the daily quota, without
+//it a client could probe the whole number space
+type ContactImport struct {
+	BaseModel
+
+	UserRefer uint `json:"userId" sql:"index"`
+	Hashes    int  `json:"hashes"`
+}
+
+//ContactMatch is a user found for one of the imported hashes
+type ContactMatch struct {
+	Hash string `json:"hash"`
+	User User   `json:"user"`
+}
+
+//NormalizeEmail is the form emails are hashed in
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+//ContactHash hashes a normalized email the same way the
+//clients do, with the salt they get from /contacts/salt
+func ContactHash(normalized string) string {
+	if normalized == "" {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(config.Cfg.ContactSettings.HashSalt + ":" + normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+//SetContactHashes updates the hash the user can be found by, the email only
+//counts once it was verified
+func (user *User) SetContactHashes() {
+	user.EmailHash = ""
+	if user.Email != "" && NormalizeEmail(user.Email) == NormalizeEmail(user.VerifiedEmail) {
+		user.EmailHash = ContactHash(NormalizeEmail(user.Email))
+	}
+}
+
+//MarkEmailVerified remembers the current email as verified, in the form
+//emails are compared in
+func (user *User) MarkEmailVerified() {
+	user.VerifiedEmail = NormalizeEmail(user.Email)
+}
+
+//BackfillContactHashes updates the hashes of the users with a verified
+//email, e.g. after the salt changed
+func BackfillContactHashes() {
+	db := GetDatabaseSession()
+
+	var lastID uint
+	for {
+		var users []User
+		db.Where("id > ? AND verified_email != ''", lastID).Order("id asc").Limit(500).Find(&users)
+		if len(users) == 0 {
+			return
+		}
+
+		for _, user := range users {
+			emailHash := user.EmailHash
+			user.SetContactHashes()
+			if user.EmailHash != emailHash {
+				db.Exec("UPDATE users SET email_hash = ? WHERE id = ?", user.EmailHash, user.ID)
+			}
+			lastID = user.ID
+		}
+	}
+}
+
+//ImportContacts matches the hashed emails of an address book against the
+//users. Only the matches are remembered, for suggestions.
+func (user *User) ImportContacts(hashes []string) ([]ContactMatch, error) {
+	db := GetDatabaseSession()
+
+	maxHashes := config.Cfg.ContactSettings.MaxHashesPerImport
+	if maxHashes > 0 && len(hashes) > maxHashes {
+		return nil, ErrTooManyContacts
+	}
+
+	// users without a verified email have empty hashes, those never match
+	requested := make(map[string]bool)
+	var cleanedHashes []string
+	for _, hash := range hashes {
+		hash = strings.ToLower(strings.TrimSpace(hash))
+		if len(hash) == sha256.Size*2 && !requested[hash] {
+			requested[hash] = true
+			cleanedHashes = append(cleanedHashes, hash)
+		}
+	}
+
+	matches := []ContactMatch{}
+	if len(cleanedHashes) == 0 {
+		return matches, nil
+	}
+
+	if maxPerDay := config.Cfg.ContactSettings.MaxHashesPerDay; maxPerDay > 0 {
+		var imported int
+		db.Raw("SELECT COALESCE(SUM(hashes), 0) FROM contact_imports WHERE user_refer = ? AND created_at > ? AND deleted_at IS NULL",
+			user.ID, time.Now().Add(-24*time.Hour)).Row().Scan(&imported)
+		if imported+len(cleanedHashes) > maxPerDay {
+			userLogger.Info(fmt.Sprintf("user '%d' reached the contact import quota", user.ID))
+			return nil, ErrContactImportQuota
+		}
+	}
+	if result := db.Create(&ContactImport{UserRefer: user.ID, Hashes: len(cleanedHashes)}); result.Error != nil {
+		return nil, result.Error
+	}
+
+	var users []User
+	blockedQuery := "id NOT IN (SELECT blocked_refer FROM blocks WHERE blocker_refer = ?) AND id NOT IN (SELECT blocker_refer FROM blocks WHERE blocked_refer = ?)"
+	result := db.Where("email_hash IN (?) AND id != ? AND is_bot = 0 AND discoverable = 1", cleanedHashes, user.ID).
+		Where(blockedQuery, user.ID, user.ID).Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	var contactIDs []int
+	for _, matchedUser := range users {
+		matchedUser.Email = ""
+		matches = append(matches, ContactMatch{
+			Hash: matchedUser.EmailHash,
+			User: matchedUser,
+		})
+		contactIDs = append(contactIDs, int(matchedUser.ID))
+	}
+
+	user.RecordContacts(contactIDs, ContactSourceAddressBook)
+	userLogger.Debug(fmt.Sprintf("user '%d' imported %d contacts with %d matches", user.ID, len(hashes), len(matches)))
+
+	return matches, nil
+}
+
+//CleanUp removes the imports which no longer count for the quota
+func (contactImport ContactImport) CleanUp() {
+	db := GetDatabaseSession()
+	db.Unscoped().Where("created_at < ?", time.Now().Add(-24*time.Hour)).Delete(ContactImport{})
+}
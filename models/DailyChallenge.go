@@ -0,0 +1,234 @@
+package models
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+
+	"timedrop/config"
+)
+
+var (
+	DailyAttemptStateStarted   = "started"
+	DailyAttemptStateCompleted = "completed"
+	DailyAttemptStateExpired   = "expired"
+
+	CoinReasonDaily = "daily"
+)
+
+var (
+	ErrDailyAlreadyAttempted = errors.New("daily_already_attempted")
+	ErrDailyNotStarted       = errors.New("daily_not_started")
+	ErrDailyExpired          = errors.New("daily_expired")
+)
+
+const dailyDateFormat = "2006-01-02"
+
+//DailyChallenge is the map and mode all players get on a day
+type DailyChallenge struct {
+	Date  string `json:"date"`
+	Seed  int64  `json:"seed"`
+	MapID int    `json:"mapId"`
+	Type  string `json:"type"`
+}
+
+//DailyAttempt is the single attempt of a user at a daily challenge
+type DailyAttempt struct {
+	BaseModel
+
+	UserRefer   uint       `json:"userId" gorm:"unique_index:idx_daily_attempt"`
+	Date        string     `json:"date" gorm:"unique_index:idx_daily_attempt"`
+	State       string     `json:"state"`
+	Score       int        `json:"score"`
+	StartedAt   time.Time  `json:"startedAt"`
+	CompletedAt *time.Time `json:"completedAt"`
+	Streak      int        `json:"streak"`
+	RewardCoins int        `json:"rewardCoins"`
+
+	User User `json:"user" gorm:"-"`
+}
+
+//DailyDate returns the challenge date of the given time, days change at
+//midnight UTC
+func DailyDate(now time.Time) string {
+	return now.UTC().Format(dailyDateFormat)
+}
+
+//GenerateDailyChallenge derives the challenge of a date from the secret. The
+//same date and secret always give the same challenge.
+func GenerateDailyChallenge(date, secret string, mapCount int) DailyChallenge {
+	hash := sha256.Sum256([]byte(secret + ":" + date))
+	seed := binary.BigEndian.Uint64(hash[:8])
+
+	challenge := DailyChallenge{
+		Date: date,
+		Seed: int64(seed >> 1),
+		Type: GameTypes[(seed>>32)%uint64(len(GameTypes))],
+	}
+	if mapCount > 0 {
+		challenge.MapID = int(seed % uint64(mapCount))
+	}
+	return challenge
+}
+
+//GetDailyChallenge returns the challenge of today
+func GetDailyChallenge(now time.Time) DailyChallenge {
+	settings := config.Cfg.DailySettings
+	return GenerateDailyChallenge(DailyDate(now), settings.SeedSecret, settings.MapCount)
+}
+
+//dailyStreak returns the streak of an attempt based on the attempt of the
+//day before
+func dailyStreak(previous DailyAttempt, date string) int {
+	day, err := time.Parse(dailyDateFormat, date)
+	if err != nil || previous.ID == 0 || previous.State != DailyAttemptStateCompleted {
+		return 1
+	}
+	if previous.Date != day.AddDate(0, 0, -1).Format(dailyDateFormat) {
+		return 1
+	}
+	return previous.Streak + 1
+}
+
+//dailyRewardCoins returns the coins for completing a challenge with the streak
+func dailyRewardCoins(streak int, settings config.DailySettings) int {
+	bonusDays := streak - 1
+	if bonusDays > settings.MaxStreakBonusDays {
+		bonusDays = settings.MaxStreakBonusDays
+	}
+	if bonusDays < 0 {
+		bonusDays = 0
+	}
+	return settings.RewardCoins + bonusDays*settings.StreakBonusCoins
+}
+
+//FindDailyAttempt returns the attempt of the user for the date
+func (user *User) FindDailyAttempt(date string) (DailyAttempt, error) {
+	db := GetDatabaseSession()
+
+	var attempt DailyAttempt
+	result := db.Where("user_refer = ? AND date = ?", user.ID, date).First(&attempt)
+	return attempt, result.Error
+}
+
+//DailyStreak returns the current streak, it is kept as long as yesterday's
+//challenge was completed
+func (user *User) DailyStreak(now time.Time) int {
+	db := GetDatabaseSession()
+
+	var attempt DailyAttempt
+	db.Where("user_refer = ? AND state = ?", user.ID, DailyAttemptStateCompleted).Order("date desc").First(&attempt)
+	if attempt.ID == 0 {
+		return 0
+	}
+
+	today := DailyDate(now)
+	yesterday := DailyDate(now.AddDate(0, 0, -1))
+	if attempt.Date != today && attempt.Date != yesterday {
+		return 0
+	}
+	return attempt.Streak
+}
+
+//StartDailyChallenge records the one attempt of the user for today
+func (user *User) StartDailyChallenge(now time.Time) (DailyAttempt, error) {
+	db := GetDatabaseSession()
+
+	attempt := DailyAttempt{
+		UserRefer: user.ID,
+		Date:      DailyDate(now),
+		State:     DailyAttemptStateStarted,
+		StartedAt: now,
+	}
+
+	// the unique index allows only one attempt per day
+	if result := db.Create(&attempt); result.Error != nil {
+		return DailyAttempt{}, ErrDailyAlreadyAttempted
+	}
+	return attempt, nil
+}
+
+//CompleteDailyChallenge stores the score of the started attempt of today and
+//rewards the user
+func (user *User) CompleteDailyChallenge(score int, now time.Time) (DailyAttempt, error) {
+	tmpLog := userLogger.New("func", "CompleteDailyChallenge")
+	db := GetDatabaseSession()
+	settings := config.Cfg.DailySettings
+
+	// a challenge started shortly before midnight can still be finished
+	maxDuration := time.Duration(settings.MaxDurationMinutes) * time.Minute
+
+	var attempt DailyAttempt
+	db.Where("user_refer = ? AND state = ? AND started_at >= ?", user.ID, DailyAttemptStateStarted, now.Add(-24*time.Hour)).
+		Order("started_at desc").First(&attempt)
+	if attempt.ID == 0 {
+		return DailyAttempt{}, ErrDailyNotStarted
+	}
+
+	if maxDuration > 0 && now.Sub(attempt.StartedAt) > maxDuration {
+		db.Model(&attempt).Update("state", DailyAttemptStateExpired)
+		return DailyAttempt{}, ErrDailyExpired
+	}
+
+	var previous DailyAttempt
+	if day, err := time.Parse(dailyDateFormat, attempt.Date); err == nil {
+		db.Where("user_refer = ? AND date = ?", user.ID, day.AddDate(0, 0, -1).Format(dailyDateFormat)).First(&previous)
+	}
+
+	streak := dailyStreak(previous, attempt.Date)
+	rewardCoins := dailyRewardCoins(streak, settings)
+
+	// only the request which completes the attempt gets the reward
+	result := db.Exec("UPDATE daily_attempts SET state = ?, score = ?, completed_at = ?, streak = ?, reward_coins = ? WHERE id = ? AND state = ?",
+		DailyAttemptStateCompleted, score, now, streak, rewardCoins, attempt.ID, DailyAttemptStateStarted)
+	if result.Error != nil {
+		return DailyAttempt{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return DailyAttempt{}, ErrDailyNotStarted
+	}
+
+	if rewardCoins > 0 {
+		idempotencyKey := CoinReasonDaily + ":" + attempt.Date
+		if _, err := user.GrantCoins(rewardCoins, CoinReasonDaily, idempotencyKey); err != nil {
+			tmpLog.Error(fmt.Sprintf("couldn't grant daily coins to user '%d': %v", user.ID, err))
+		}
+	}
+
+	attempt.State = DailyAttemptStateCompleted
+	attempt.Score = score
+	attempt.CompletedAt = &now
+	attempt.Streak = streak
+	attempt.RewardCoins = rewardCoins
+
+	return attempt, nil
+}
+
+//FindDailyLeaderboard lists the best completed attempts of a date. Like in
+//regular games the lower score wins, ties go to the earlier result.
+func (attempt DailyAttempt) FindDailyLeaderboard(date string, limit int) (attempts []DailyAttempt, err error) {
+	db := GetDatabaseSession()
+	result := db.Where("date = ? AND state = ?", date, DailyAttemptStateCompleted).
+		Order("score asc, completed_at asc").Limit(limit).Find(&attempts)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	for i := range attempts {
+		attempts[i].User.FindByID(attempts[i].UserRefer)
+		attempts[i].User.Email = ""
+	}
+	return attempts, nil
+}
+
+//DailyRank returns the position of the attempt on the leaderboard of its date
+func (attempt DailyAttempt) DailyRank() int {
+	db := GetDatabaseSession()
+
+	var better int
+	db.Model(&DailyAttempt{}).Where("date = ? AND state = ? AND (score < ? OR (score = ? AND completed_at < ?))",
+		attempt.Date, DailyAttemptStateCompleted, attempt.Score, attempt.Score, attempt.CompletedAt).Count(&better)
+	return better + 1
+}
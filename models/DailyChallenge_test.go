@@ -0,0 +1,60 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestGenerateDailyChallenge(t *testing.T) {
+	challenge := GenerateDailyChallenge("2018-03-10", "secret", 20)
+
+	if again := GenerateDailyChallenge("2018-03-10", "secret", 20); again != challenge {
+		t.Errorf("same date and secret gave %+v and %+v", challenge, again)
+	}
+	if challenge.Date != "2018-03-10" {
+		t.Errorf("got date %s, want 2018-03-10", challenge.Date)
+	}
+	if challenge.Seed < 0 {
+		t.Errorf("got negative seed %d", challenge.Seed)
+	}
+	if !containsString(GameTypes, challenge.Type) {
+		t.Errorf("got unknown type %s", challenge.Type)
+	}
+
+	if other := GenerateDailyChallenge("2018-03-10", "other secret", 20); other.Seed == challenge.Seed {
+		t.Errorf("another secret gave the same seed %d", other.Seed)
+	}
+	if nextDay := GenerateDailyChallenge("2018-03-11", "secret", 20); nextDay.Seed == challenge.Seed {
+		t.Errorf("the next day got the same seed %d", nextDay.Seed)
+	}
+
+	for day := 1; day <= 31; day++ {
+		date := time.Date(2018, 3, day, 0, 0, 0, 0, time.UTC).Format(dailyDateFormat)
+		if mapID := GenerateDailyChallenge(date, "secret", 20).MapID; mapID < 0 || mapID >= 20 {
+			t.Errorf("%s: got map %d, want one of 20 maps", date, mapID)
+		}
+	}
+
+	if mapID := GenerateDailyChallenge("2018-03-10", "secret", 0).MapID; mapID != 0 {
+		t.Errorf("without maps got map %d, want 0", mapID)
+	}
+}
+
+func TestDailyDate(t *testing.T) {
+	berlin := time.FixedZone("CET", 3600)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want string
+	}{
+		{"utc", time.Date(2018, 3, 10, 15, 0, 0, 0, time.UTC), "2018-03-10"},
+		{"days change at midnight utc", time.Date(2018, 3, 11, 0, 30, 0, 0, berlin), "2018-03-10"},
+	}
+
+	for _, test := range tests {
+		if got := DailyDate(test.now); got != test.want {
+			t.Errorf("%s: got %s, want %s", test.name, got, test.want)
+		}
+	}
+}
@@ -0,0 +1,132 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+//FacebookIdentityProvider accepts both kinds of Facebook tokens, the ID
+//tokens of Limited Login and the access tokens of the classic login
+type FacebookIdentityProvider struct {
+	IDTokens     IdentityProvider
+	AccessTokens IdentityProvider
+}
+
+//Verify a Facebook token, ID tokens are JWTs with three parts
+func (provider FacebookIdentityProvider) Verify(token string) (VerifiedIdentity, error) {
+	if strings.Count(token, ".") == 2 {
+		return provider.IDTokens.Verify(token)
+	}
+	return provider.AccessTokens.Verify(token)
+}
+
+//FacebookGraphProvider verifies access tokens with the debug endpoint of the
+//Graph API, the Facebook ID comes from Facebook and never from the client
+type FacebookGraphProvider struct {
+	GraphURL  string
+	AppIDs    []string
+	AppSecret string
+}
+
+type facebookDebugTokenResponse struct {
+	Data struct {
+		AppID   string `json:"app_id"`
+		IsValid bool   `json:"is_valid"`
+		UserID  string `json:"user_id"`
+	} `json:"data"`
+}
+
+type facebookProfileResponse struct {
+	Name string `json:"name"`
+}
+
+//Verify an access token
+func (provider FacebookGraphProvider) Verify(accessToken string) (VerifiedIdentity, error) {
+	if accessToken == "" || len(provider.AppIDs) == 0 || provider.AppSecret == "" {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	// the app access token of the first app can inspect the tokens of all apps
+	query := url.Values{
+		"input_token":  {accessToken},
+		"access_token": {provider.AppIDs[0] + "|" + provider.AppSecret},
+	}
+	res, err := identityHTTPClient.Get(provider.GraphURL + "/debug_token?" + query.Encode())
+	if err != nil {
+		return VerifiedIdentity{}, ErrIdentityUnavailable
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode >= 500 {
+		return VerifiedIdentity{}, ErrIdentityUnavailable
+	}
+	if res.StatusCode != 200 {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	var debugToken facebookDebugTokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&debugToken); err != nil {
+		return VerifiedIdentity{}, ErrIdentityUnavailable
+	}
+
+	if !debugToken.Data.IsValid || debugToken.Data.UserID == "" || !containsString(provider.AppIDs, debugToken.Data.AppID) {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	return VerifiedIdentity{
+		Provider: IdentityProviderFacebook,
+		Subject:  debugToken.Data.UserID,
+		Name:     provider.profileName(accessToken),
+	}, nil
+}
+
+//profileName looks up the name of the token owner, it is only shown so a
+//failed lookup is ignored
+func (provider FacebookGraphProvider) profileName(accessToken string) string {
+	query := url.Values{
+		"fields":       {"name"},
+		"access_token": {accessToken},
+	}
+	res, err := identityHTTPClient.Get(provider.GraphURL + "/me?" + query.Encode())
+	if err != nil {
+		return ""
+	}
+	defer res.Body.Close()
+
+	var profile facebookProfileResponse
+	if res.StatusCode != 200 || json.NewDecoder(res.Body).Decode(&profile) != nil {
+		userLogger.Debug(fmt.Sprintf("couldn't load facebook profile, status %d", res.StatusCode))
+		return ""
+	}
+	return profile.Name
+}
+
+//FindUserByIdentity finds the user a verified identity belongs to. Facebook
+//IDs saved before identities existed are never trusted, clients could set
+//them to any value.
+func FindUserByIdentity(identity VerifiedIdentity) (user User, err error) {
+	db := GetDatabaseSession()
+
+	var userIdentity UserIdentity
+	db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&userIdentity)
+	if userIdentity.ID != 0 {
+		err = user.FindByID(userIdentity.UserRefer)
+		return user, err
+	}
+
+	return User{}, ErrUserNotFound
+}
+
+//hasLegacyFacebookID checks if a user has the Facebook ID without an identity,
+//the owner has to log in another way and link Facebook to take it over
+func hasLegacyFacebookID(identity VerifiedIdentity) bool {
+	if identity.Provider != IdentityProviderFacebook {
+		return false
+	}
+
+	var count int
+	GetDatabaseSession().Model(&User{}).Where("facebook_id = ?", identity.Subject).Count(&count)
+	return count > 0
+}
@@ -0,0 +1,221 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"timedrop/config"
+)
+
+var (
+	FeedTypeLevelUp             = "level_up"
+	FeedTypeAchievementUnlocked = "achievement_unlocked"
+	FeedTypeBeatYou             = "beat_you"
+	FeedTypeHighScore           = "high_score"
+)
+
+//FeedItem is an event of a friend. Items are written to the feed of every
+//friend when they happen, so reading a feed is a single query.
+type FeedItem struct {
+	BaseModel
+
+	OwnerRefer uint   `json:"-" sql:"index"`
+	ActorRefer uint   `json:"actorId" sql:"index"`
+	Type       string `json:"type"`
+	// Data holds the details of the event as JSON, depending on the type
+	Data string `json:"data" sql:"type:text"`
+
+	Actor User `json:"actor" gorm:"-"`
+}
+
+type feedLevelUpData struct {
+	Level string `json:"level"`
+}
+
+type feedAchievementData struct {
+	Key  string `json:"key"`
+	Name string `json:"name"`
+}
+
+type feedGameData struct {
+	GameID uint   `json:"gameId"`
+	MapID  int    `json:"mapId"`
+	Type   string `json:"gameType"`
+	Score  int    `json:"score"`
+}
+
+func init() {
+	OnLevelChange(func(change LevelChange) {
+		if change.IsPromotion() {
+			publishToFriends(change.User, FeedTypeLevelUp, feedLevelUpData{Level: change.ToLevel.Name})
+		}
+	})
+	OnAchievementUnlocked(func(user User, definition config.AchievementDefinition) {
+		publishToFriends(user, FeedTypeAchievementUnlocked, feedAchievementData{Key: definition.Key, Name: definition.Name})
+	})
+	OnGameCompleted(publishGameEvents)
+}
+
+//publishToFriends writes the event to the feed of every friend of the actor,
+//unless the actor doesn't share activity
+func publishToFriends(actor User, feedType string, data interface{}) {
+	if actor.IsBot || !actor.ShareActivity {
+		return
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	db := GetDatabaseSession()
+	result := db.Exec("INSERT INTO feed_items (owner_refer, actor_refer, type, data, created_at, updated_at) "+
+		"SELECT receiver_refer, ?, ?, ?, NOW(), NOW() FROM friends WHERE requester_refer = ? UNION "+
+		"SELECT requester_refer, ?, ?, ?, NOW(), NOW() FROM friends WHERE receiver_refer = ?",
+		actor.ID, feedType, string(encoded), actor.ID, actor.ID, feedType, string(encoded), actor.ID)
+	if result.Error != nil {
+		userLogger.Error(fmt.Sprintf("couldn't publish '%s' of user '%d': %v", feedType, actor.ID, result.Error))
+	}
+}
+
+//publishToUser writes the event to the feed of a single friend
+func publishToUser(actor User, ownerID uint, feedType string, data interface{}) {
+	if actor.IsBot || !actor.ShareActivity {
+		return
+	}
+
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return
+	}
+
+	feedItem := FeedItem{
+		OwnerRefer: ownerID,
+		ActorRefer: actor.ID,
+		Type:       feedType,
+		Data:       string(encoded),
+	}
+
+	db := GetDatabaseSession()
+	if result := db.Create(&feedItem); result.Error != nil {
+		userLogger.Error(fmt.Sprintf("couldn't publish '%s' of user '%d': %v", feedType, actor.ID, result.Error))
+	}
+}
+
+//publishGameEvents tells a beaten friend about it and publishes new best
+//scores of both players on the map
+func publishGameEvents(game Game) {
+	if game.AgainstBot {
+		return
+	}
+
+	players := []struct {
+		ID    uint
+		Score int
+	}{
+		{game.CreatorRefer, game.ScoreCreator},
+		{game.OpponentRefer, game.ScoreOpponent},
+	}
+
+	for _, player := range players {
+		if player.ID == 0 {
+			continue
+		}
+
+		var user User
+		if err := user.FindByID(player.ID); err != nil {
+			continue
+		}
+
+		data := feedGameData{
+			GameID: game.ID,
+			MapID:  game.MapID,
+			Type:   game.Type,
+			Score:  player.Score,
+		}
+
+		var friend Friend
+		if player.ID == game.WonRefer && game.LostRefer != 0 && friend.IsAlreadyFriendsWith(game.WonRefer, game.LostRefer) {
+			publishToUser(user, game.LostRefer, FeedTypeBeatYou, data)
+		}
+
+		if game.isBestScoreOf(player.ID, player.Score) {
+			publishToFriends(user, FeedTypeHighScore, data)
+		}
+	}
+}
+
+//isBestScoreOf checks if the score beats every earlier score of the user on
+//the map and mode, lower scores are better. The first game doesn't count.
+func (game Game) isBestScoreOf(userID uint, score int) bool {
+	if score <= 0 {
+		return false
+	}
+
+	db := GetDatabaseSession()
+
+	var count int
+	var best int
+	db.Raw("SELECT COUNT(*), COALESCE(MIN(s.score), 0) FROM ("+
+		"SELECT score_creator AS score FROM games WHERE creator_refer = ? AND map_id = ? AND type = ? AND completed = 1 AND id != ? AND score_creator > 0 AND deleted_at IS NULL UNION ALL "+
+		"SELECT score_opponent AS score FROM games WHERE opponent_refer = ? AND map_id = ? AND type = ? AND completed = 1 AND id != ? AND score_opponent > 0 AND deleted_at IS NULL) s",
+		userID, game.MapID, game.Type, game.ID, userID, game.MapID, game.Type, game.ID).Row().Scan(&count, &best)
+
+	return count > 0 && score < best
+}
+
+//FindFeed returns the feed of the user, newest first. Pages continue with
+//the items older than beforeID.
+func (user *User) FindFeed(beforeID uint, limit int) ([]FeedItem, error) {
+	db := GetDatabaseSession()
+
+	query := db.Where("owner_refer = ?", user.ID)
+	if beforeID != 0 {
+		query = query.Where("id < ?", beforeID)
+	}
+
+	var feedItems []FeedItem
+	if result := query.Order("id desc").Limit(limit).Find(&feedItems); result.Error != nil {
+		return nil, result.Error
+	}
+
+	actors := make(map[uint]User)
+	for i := range feedItems {
+		actor, ok := actors[feedItems[i].ActorRefer]
+		if !ok {
+			actor.FindByID(feedItems[i].ActorRefer)
+			actor.Email = ""
+			actors[feedItems[i].ActorRefer] = actor
+		}
+		feedItems[i].Actor = actor
+	}
+
+	return feedItems, nil
+}
+
+//SetShareActivity changes whether friends see the activity of the user,
+//turning it off removes what was shared before
+func (user *User) SetShareActivity(shareActivity bool) error {
+	user.ShareActivity = shareActivity
+	if err := user.Save(); err != nil {
+		return err
+	}
+
+	if !shareActivity {
+		db := GetDatabaseSession()
+		return db.Unscoped().Where("actor_refer = ?", user.ID).Delete(FeedItem{}).Error
+	}
+	return nil
+}
+
+//CleanUp removes the feed items older than the retention period
+func (feedItem FeedItem) CleanUp() {
+	retentionDays := config.Cfg.FeedSettings.RetentionDays
+	if retentionDays <= 0 {
+		return
+	}
+
+	db := GetDatabaseSession()
+	db.Unscoped().Where("created_at < ?", time.Now().AddDate(0, 0, -retentionDays)).Delete(&FeedItem{})
+}
@@ -1,6 +1,11 @@
 package models
 
-import "errors"
+import (
+	"errors"
+	"time"
+
+	"timedrop/config"
+)
 
 //Friend handels friend (due to a gorm bug)
 type Friend struct {
@@ -10,6 +15,20 @@ type Friend struct {
 	ReceiverRefer  uint `gorm:"unique_index:idx_friend_ids"`
 }
 
+//friendAddedListeners are called after two users became friends
+var friendAddedListeners []func(Friend)
+
+//OnFriendAdded registers a listener for new friendships
+func OnFriendAdded(listener func(Friend)) {
+	friendAddedListeners = append(friendAddedListeners, listener)
+}
+
+func emitFriendAdded(friend Friend) {
+	for _, listener := range friendAddedListeners {
+		listener(friend)
+	}
+}
+
 //FindByUserID find friends by a user id
 func (friend *Friend) FindByUserID(userID interface{}) (friends []Friend, err error) {
 	db := GetDatabaseSession()
@@ -38,12 +57,44 @@ func (friend *Friend) IsAlreadyFriendsWith(userID, friendID interface{}) bool {
 	return count > 0
 }
 
-//FriendRequest handels friend requests
+var (
+	FriendRequestStatusPending   = "pending"
+	FriendRequestStatusAccepted  = "accepted"
+	FriendRequestStatusDeclined  = "declined"
+	FriendRequestStatusCancelled = "cancelled"
+	FriendRequestStatusExpired   = "expired"
+)
+
+var (
+	ErrAlreadyFriends            = errors.New("already_friends")
+	ErrCanNotFriendYourself      = errors.New("can_not_friend_yourself")
+	ErrFriendRequestNotFound     = errors.New("friend_request_not_found")
+	ErrFriendRequestNotReceiver  = errors.New("friend_request_not_receiver")
+	ErrFriendRequestNotRequester = errors.New("friend_request_not_requester")
+	ErrFriendRequestNotPending   = errors.New("friend_request_not_pending")
+	ErrFriendRequestCooldown     = errors.New("friend_request_cooldown")
+)
+
+//FriendRequest handels friend requests. There is one row per direction, a
+//new request after a decline, cancellation or expiry reuses it.
 type FriendRequest struct {
 	BaseModel
 
 	RequesterRefer uint `gorm:"unique_index:idx_freq_ids"`
 	ReceiverRefer  uint `gorm:"unique_index:idx_freq_ids"`
+
+	Status      string     `json:"status" sql:"default:'pending';index"`
+	ExpiresAt   *time.Time `json:"expiresAt"`
+	RespondedAt *time.Time `json:"respondedAt"`
+}
+
+// pending requests which are still valid, requests from before the expiry
+// was stored count from their creation
+const pendingFriendRequestQuery = "status = 'pending' AND (expires_at > ? OR (expires_at IS NULL AND created_at > ?))"
+
+func pendingFriendRequestArgs(now time.Time) []interface{} {
+	expiryDays := config.Cfg.FriendRequestSettings.ExpiryDays
+	return []interface{}{now, now.AddDate(0, 0, -expiryDays)}
 }
 
 //Save friend request
@@ -53,21 +104,93 @@ func (friendRequest *FriendRequest) Save() error {
 	return result.Error
 }
 
-//FindOpenFriendRequestsByUserID friend request
+//FindOpenFriendRequestsByUserID returns the pending requests the user received
 func (friendRequest *FriendRequest) FindOpenFriendRequestsByUserID(userID interface{}) (friendRequests []FriendRequest, err error) {
 	db := GetDatabaseSession()
-	result := db.Where("receiver_refer = ?", userID).Find(&friendRequests)
+	result := db.Where("receiver_refer = ?", userID).
+		Where(pendingFriendRequestQuery, pendingFriendRequestArgs(time.Now())...).Find(&friendRequests)
 	return friendRequests, result.Error
 }
 
-//FindPendingFriendRequestsByUserID friend request
+//FindPendingFriendRequestsByUserID returns the pending requests the user sent
 func (friendRequest *FriendRequest) FindPendingFriendRequestsByUserID(userID interface{}) (friendRequests []FriendRequest, err error) {
 	db := GetDatabaseSession()
-	result := db.Where("requester_refer = ?", userID).Find(&friendRequests)
+	result := db.Where("requester_refer = ?", userID).
+		Where(pendingFriendRequestQuery, pendingFriendRequestArgs(time.Now())...).Find(&friendRequests)
 	return friendRequests, result.Error
 }
 
-//FindAndAcceptFriendRequest handels friend creation
+//isPending checks the status and the expiry
+func (friendRequest FriendRequest) isPending(now time.Time) bool {
+	if friendRequest.Status != FriendRequestStatusPending {
+		return false
+	}
+	if friendRequest.ExpiresAt != nil {
+		return friendRequest.ExpiresAt.After(now)
+	}
+	expiryDays := config.Cfg.FriendRequestSettings.ExpiryDays
+	return friendRequest.CreatedAt.After(now.AddDate(0, 0, -expiryDays))
+}
+
+//SendFriendRequest asks the receiver for friendship. Sending it again while
+//it is pending returns the same request, a pending request in the other
+//direction gets accepted instead. created reports if a new request was sent.
+func (user *User) SendFriendRequest(receiverID uint) (friendRequest FriendRequest, created bool, err error) {
+	db := GetDatabaseSession()
+	now := time.Now()
+
+	if receiverID == user.ID {
+		return FriendRequest{}, false, ErrCanNotFriendYourself
+	}
+
+	var friend Friend
+	if friend.IsAlreadyFriendsWith(user.ID, receiverID) {
+		return FriendRequest{}, false, ErrAlreadyFriends
+	}
+
+	var block Block
+	if block.IsBlockedBetween(user.ID, receiverID) {
+		return FriendRequest{}, false, ErrUserBlocked
+	}
+
+	var reverseRequest FriendRequest
+	db.Where("requester_refer = ? AND receiver_refer = ?", receiverID, user.ID).First(&reverseRequest)
+	if reverseRequest.ID != 0 && reverseRequest.isPending(now) {
+		if !reverseRequest.FindAndAcceptFriendRequest(reverseRequest.ID, user.ID) {
+			return FriendRequest{}, false, ErrFriendRequestNotPending
+		}
+		reverseRequest.Status = FriendRequestStatusAccepted
+		return reverseRequest, false, nil
+	}
+
+	// soft deleted rows still hold the unique index
+	db.Unscoped().Where("requester_refer = ? AND receiver_refer = ?", user.ID, receiverID).First(&friendRequest)
+	if friendRequest.ID != 0 && friendRequest.DeletedAt == nil && friendRequest.isPending(now) {
+		return friendRequest, false, nil
+	}
+
+	cooldownDays := config.Cfg.FriendRequestSettings.DeclineCooldownDays
+	if friendRequest.Status == FriendRequestStatusDeclined && friendRequest.RespondedAt != nil &&
+		friendRequest.RespondedAt.After(now.AddDate(0, 0, -cooldownDays)) {
+		return FriendRequest{}, false, ErrFriendRequestCooldown
+	}
+
+	expiresAt := now.AddDate(0, 0, config.Cfg.FriendRequestSettings.ExpiryDays)
+	friendRequest.RequesterRefer = user.ID
+	friendRequest.ReceiverRefer = receiverID
+	friendRequest.Status = FriendRequestStatusPending
+	friendRequest.ExpiresAt = &expiresAt
+	friendRequest.RespondedAt = nil
+	friendRequest.DeletedAt = nil
+	if result := db.Unscoped().Save(&friendRequest); result.Error != nil {
+		return FriendRequest{}, false, result.Error
+	}
+
+	return friendRequest, true, nil
+}
+
+//FindAndAcceptFriendRequest handels friend creation, accepting a request
+//twice succeeds
 func (friendRequest FriendRequest) FindAndAcceptFriendRequest(friendRequestID, receiverID interface{}) bool {
 	db := GetDatabaseSession()
 	result := db.First(&friendRequest, friendRequestID)
@@ -84,6 +207,15 @@ func (friendRequest FriendRequest) FindAndAcceptFriendRequest(friendRequestID, r
 
 	var friend Friend
 	if areFriends := friend.IsAlreadyFriendsWith(friendRequest.ReceiverRefer, friendRequest.RequesterRefer); areFriends {
+		return friendRequest.Status == FriendRequestStatusAccepted
+	}
+
+	if !friendRequest.isPending(time.Now()) {
+		return false
+	}
+
+	var block Block
+	if block.IsBlockedBetween(friendRequest.ReceiverRefer, friendRequest.RequesterRefer) {
 		return false
 	}
 
@@ -93,6 +225,14 @@ func (friendRequest FriendRequest) FindAndAcceptFriendRequest(friendRequestID, r
 		return false
 	}
 
+	// only one of concurrent accepts gets through
+	now := time.Now()
+	result = db.Exec("UPDATE friend_requests SET status = ?, responded_at = ? WHERE id = ? AND status = ?",
+		FriendRequestStatusAccepted, now, friendRequest.ID, FriendRequestStatusPending)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false
+	}
+
 	friend = Friend{
 		ReceiverRefer:  friendRequest.ReceiverRefer,
 		RequesterRefer: friendRequest.RequesterRefer,
@@ -105,32 +245,79 @@ func (friendRequest FriendRequest) FindAndAcceptFriendRequest(friendRequestID, r
 	var pushNotification PushNotification
 	go pushNotification.SendFriendRequestAcceptedPush(friendUser)
 
-	// If user a sent a friend request to user b then the
-	// request for user b -> user a should also be deleted
-	sqlQuery := "requester_refer = ? AND receiver_refer = ?"
-	db.Where(sqlQuery, friendRequest.ReceiverRefer, friendRequest.RequesterRefer).Delete(FriendRequest{})
+	emitFriendAdded(friend)
 
-	// Delete actual friend request
-	db.Unscoped().Delete(&friendRequest)
+	// If user a sent a friend request to user b then the
+	// request for user b -> user a is accepted as well
+	db.Exec("UPDATE friend_requests SET status = ?, responded_at = ? WHERE requester_refer = ? AND receiver_refer = ? AND status = ?",
+		FriendRequestStatusAccepted, now, friendRequest.ReceiverRefer, friendRequest.RequesterRefer, FriendRequestStatusPending)
 
 	return true
 }
 
-//FindAndDecclineFriendRequest handels friend request denial
+//respond moves a pending request to the given status, a request which is
+//already in that status is left as it is
+func (friendRequest *FriendRequest) respond(status string) error {
+	db := GetDatabaseSession()
+
+	if friendRequest.Status == status {
+		return nil
+	}
+	if !friendRequest.isPending(time.Now()) {
+		return ErrFriendRequestNotPending
+	}
+
+	now := time.Now()
+	result := db.Exec("UPDATE friend_requests SET status = ?, responded_at = ? WHERE id = ? AND status = ?",
+		status, now, friendRequest.ID, FriendRequestStatusPending)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrFriendRequestNotPending
+	}
+
+	friendRequest.Status = status
+	friendRequest.RespondedAt = &now
+	return nil
+}
+
+//FindAndDecclineFriendRequest handels friend request denial by the receiver
 func (friendRequest FriendRequest) FindAndDecclineFriendRequest(friendRequestID interface{}, currentUserID uint) error {
 	db := GetDatabaseSession()
 
 	if result := db.First(&friendRequest, friendRequestID); result.Error != nil {
-		return errors.New("friend_request_not_found")
+		return ErrFriendRequestNotFound
 	}
 
 	if friendRequest.ReceiverRefer != currentUserID {
-		return errors.New("friend_request_not_receiver")
+		return ErrFriendRequestNotReceiver
 	}
 
-	if result := db.Unscoped().Delete(&friendRequest); result.Error != nil {
-		return result.Error
+	return friendRequest.respond(FriendRequestStatusDeclined)
+}
+
+//FindAndCancelFriendRequest withdraws a request, only the requester can
+func (friendRequest FriendRequest) FindAndCancelFriendRequest(friendRequestID interface{}, currentUserID uint) error {
+	db := GetDatabaseSession()
+
+	if result := db.First(&friendRequest, friendRequestID); result.Error != nil {
+		return ErrFriendRequestNotFound
 	}
 
-	return nil
+	if friendRequest.RequesterRefer != currentUserID {
+		return ErrFriendRequestNotRequester
+	}
+
+	return friendRequest.respond(FriendRequestStatusCancelled)
+}
+
+//CleanUp marks the pending requests as expired which weren't answered in time
+func (friendRequest FriendRequest) CleanUp() {
+	db := GetDatabaseSession()
+	now := time.Now()
+	expiryDays := config.Cfg.FriendRequestSettings.ExpiryDays
+
+	db.Exec("UPDATE friend_requests SET status = ? WHERE status = ? AND (expires_at <= ? OR (expires_at IS NULL AND created_at <= ?))",
+		FriendRequestStatusExpired, FriendRequestStatusPending, now, now.AddDate(0, 0, -expiryDays))
 }
@@ -0,0 +1,155 @@
+package models
+
+import (
+	"sort"
+	"time"
+
+	"timedrop/config"
+)
+
+var (
+	SuggestionReasonMutualFriends = "mutual_friends"
+	SuggestionReasonOpponent      = "opponent"
+	SuggestionReasonContact       = "contact"
+)
+
+//ContactLink remembers that a user was found in an import of another user,
+//it is the only thing kept from an import
+type ContactLink struct {
+	BaseModel
+
+	UserRefer    uint   `json:"userId" gorm:"unique_index:idx_contact_link"`
+	ContactRefer uint   `json:"contactId" gorm:"unique_index:idx_contact_link"`
+	Source       string `json:"source"`
+}
+
+//FriendSuggestion is a user the current user might know
+type FriendSuggestion struct {
+	User          User     `json:"user"`
+	Score         int      `json:"score"`
+	Reasons       []string `json:"reasons"`
+	MutualFriends int      `json:"mutualFriends"`
+}
+
+//RecordContacts links the users found by an import to the user
+func (user *User) RecordContacts(contactIDs []int, source string) {
+	db := GetDatabaseSession()
+	for _, contactID := range contactIDs {
+		db.Exec("INSERT IGNORE INTO contact_links (user_refer, contact_refer, source, created_at, updated_at) VALUES (?, ?, ?, NOW(), NOW())",
+			user.ID, contactID, source)
+	}
+}
+
+//suggestionCandidates collects the candidates of one source with a count
+//per candidate, e.g. the number of mutual friends
+func suggestionCandidates(sqlQuery string, args ...interface{}) map[uint]int {
+	db := GetDatabaseSession()
+	candidates := make(map[uint]int)
+
+	rows, err := db.Raw(sqlQuery, args...).Rows()
+	if err != nil {
+		return candidates
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var candidateID uint
+		var count int
+		rows.Scan(&candidateID, &count)
+		candidates[candidateID] = count
+	}
+	return candidates
+}
+
+//GetFriendSuggestions scores users from mutual friends, recent opponents and
+//imported contacts. Friends, users with a pending request, blocked users,
+//bots and users who aren't discoverable are left out.
+func (user *User) GetFriendSuggestions() ([]FriendSuggestion, error) {
+	db := GetDatabaseSession()
+	settings := config.Cfg.SuggestionSettings
+
+	friendsQuery := "SELECT receiver_refer FROM friends WHERE requester_refer = ? UNION SELECT requester_refer FROM friends WHERE receiver_refer = ?"
+
+	// f1 are the friendships of the user, f2 the friendships of those friends
+	mutualFriends := suggestionCandidates("SELECT m.candidate, COUNT(*) FROM ("+
+		"SELECT f2.receiver_refer AS candidate FROM friends f1 JOIN friends f2 ON f2.requester_refer = f1.receiver_refer WHERE f1.requester_refer = ? UNION ALL "+
+		"SELECT f2.requester_refer AS candidate FROM friends f1 JOIN friends f2 ON f2.receiver_refer = f1.receiver_refer WHERE f1.requester_refer = ? UNION ALL "+
+		"SELECT f2.receiver_refer AS candidate FROM friends f1 JOIN friends f2 ON f2.requester_refer = f1.requester_refer WHERE f1.receiver_refer = ? UNION ALL "+
+		"SELECT f2.requester_refer AS candidate FROM friends f1 JOIN friends f2 ON f2.receiver_refer = f1.requester_refer WHERE f1.receiver_refer = ?) m "+
+		"GROUP BY m.candidate",
+		user.ID, user.ID, user.ID, user.ID)
+
+	since := time.Now().AddDate(0, 0, -settings.OpponentDays)
+	opponents := suggestionCandidates("SELECT o.candidate, COUNT(*) FROM ("+
+		"SELECT opponent_refer AS candidate FROM games WHERE creator_refer = ? AND completed = 1 AND against_bot = 0 AND from_friend_request = 0 AND created_at >= ? AND deleted_at IS NULL UNION ALL "+
+		"SELECT creator_refer AS candidate FROM games WHERE opponent_refer = ? AND completed = 1 AND against_bot = 0 AND from_friend_request = 0 AND created_at >= ? AND deleted_at IS NULL) o "+
+		"GROUP BY o.candidate",
+		user.ID, since, user.ID, since)
+
+	contacts := suggestionCandidates("SELECT contact_refer, 1 FROM contact_links WHERE user_refer = ? AND deleted_at IS NULL", user.ID)
+
+	scores := make(map[uint]*FriendSuggestion)
+	add := func(candidates map[uint]int, weight int, reason string) {
+		for candidateID, count := range candidates {
+			if candidateID == 0 || candidateID == user.ID {
+				continue
+			}
+			suggestion, ok := scores[candidateID]
+			if !ok {
+				suggestion = &FriendSuggestion{Reasons: []string{}}
+				scores[candidateID] = suggestion
+			}
+			suggestion.Score += weight * count
+			suggestion.Reasons = append(suggestion.Reasons, reason)
+		}
+	}
+	add(mutualFriends, settings.MutualFriendWeight, SuggestionReasonMutualFriends)
+	add(opponents, settings.OpponentWeight, SuggestionReasonOpponent)
+	add(contacts, settings.ContactWeight, SuggestionReasonContact)
+
+	suggestions := []FriendSuggestion{}
+	if len(scores) == 0 {
+		return suggestions, nil
+	}
+
+	candidateIDs := make([]uint, 0, len(scores))
+	for candidateID := range scores {
+		candidateIDs = append(candidateIDs, candidateID)
+	}
+
+	blockedQuery := "id NOT IN (SELECT blocked_refer FROM blocks WHERE blocker_refer = ?) AND id NOT IN (SELECT blocker_refer FROM blocks WHERE blocked_refer = ?)"
+	pendingQuery := "id NOT IN (SELECT receiver_refer FROM friend_requests WHERE requester_refer = ? AND " + pendingFriendRequestQuery + " AND deleted_at IS NULL) " +
+		"AND id NOT IN (SELECT requester_refer FROM friend_requests WHERE receiver_refer = ? AND " + pendingFriendRequestQuery + " AND deleted_at IS NULL)"
+	now := time.Now()
+	pendingArgs := append([]interface{}{user.ID}, pendingFriendRequestArgs(now)...)
+	pendingArgs = append(pendingArgs, user.ID)
+	pendingArgs = append(pendingArgs, pendingFriendRequestArgs(now)...)
+
+	var users []User
+	result := db.Where("id IN (?) AND is_bot = 0 AND discoverable = 1", candidateIDs).
+		Where("id NOT IN ("+friendsQuery+")", user.ID, user.ID).
+		Where(blockedQuery, user.ID, user.ID).
+		Where(pendingQuery, pendingArgs...).Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	for _, candidate := range users {
+		candidate.Email = ""
+		suggestion := scores[candidate.ID]
+		suggestion.User = candidate
+		suggestion.MutualFriends = mutualFriends[candidate.ID]
+		suggestions = append(suggestions, *suggestion)
+	}
+
+	sort.SliceStable(suggestions, func(i, j int) bool {
+		if suggestions[i].Score != suggestions[j].Score {
+			return suggestions[i].Score > suggestions[j].Score
+		}
+		return suggestions[i].User.ID < suggestions[j].User.ID
+	})
+
+	if settings.Limit > 0 && len(suggestions) > settings.Limit {
+		suggestions = suggestions[:settings.Limit]
+	}
+	return suggestions, nil
+}
@@ -58,6 +58,7 @@ type Game struct {
 
 	Completed        bool   `json:"completed"`
 	AutoCompleted    bool   `json:"autoCompleted"`
+	AgainstBot       bool   `json:"againstBot"`
 	ExtraStringField string `json:"-"`
 }
 
@@ -163,6 +164,11 @@ func (game Game) FindMatchNew(currentUserID uint) (Game, error) {
 			return Game{}, errors.New("creator not found")
 		}
 
+		var block Block
+		if block.IsBlockedBetween(currentUserID, foundGame.CreatorRefer) {
+			continue
+		}
+
 		var creatorLevel Level
 		creatorLevel.FindByID(foundGame.LevelRefer)
 
@@ -238,12 +244,16 @@ func (game *Game) RewardPoints() error {
 		game.Opponent.Score += (baseScoreAddition / 2)
 	}
 
-	// Save users and scores
-	if err := game.Creator.Save(); err != nil {
-		return err
+	// Save users and scores, bots don't keep statistics
+	if !game.Creator.IsBot {
+		if err := game.Creator.Save(); err != nil {
+			return err
+		}
 	}
-	if err := game.Opponent.Save(); err != nil {
-		return err
+	if !game.Opponent.IsBot {
+		if err := game.Opponent.Save(); err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -272,6 +282,8 @@ func (game *Game) prepareAndComplete() error {
 		return err
 	}
 
+	emitGameCompleted(*game)
+
 	return nil
 }
 
@@ -309,6 +321,8 @@ func (game *Game) markGameAsLost(creatorLost bool) error {
 		return err
 	}
 
+	emitGameCompleted(*game)
+
 	return nil
 }
 
@@ -348,6 +362,9 @@ func (game Game) CleanUp() {
 
 	db.Where(unansweredGamesQuery, GameStateCompleted, GameStatePending, unansweredGamesDeadline, unansweredGamesDeadlineFrom).Delete(&Game{})
 
+	// Let bots take the games nobody matched
+	assignBots(now)
+
 	// Check for aborted games
 	abortedGamesDeadline := now.Add(-10 * time.Minute)
 
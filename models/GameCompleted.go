@@ -0,0 +1,17 @@
+package models
+
+//gameCompletedListeners are called after a game got completed and the
+//players statistics were saved
+var gameCompletedListeners []func(Game)
+
+//OnGameCompleted registers a listener for completed games. Listeners run
+//synchronously, the players of the game may not be loaded.
+func OnGameCompleted(listener func(Game)) {
+	gameCompletedListeners = append(gameCompletedListeners, listener)
+}
+
+func emitGameCompleted(game Game) {
+	for _, listener := range gameCompletedListeners {
+		listener(game)
+	}
+}
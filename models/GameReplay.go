@@ -0,0 +1,123 @@
+package models
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"timedrop/config"
+)
+
+var (
+	ErrReplayInvalid     = errors.New("replay_invalid")
+	ErrReplayTooLarge    = errors.New("replay_too_large")
+	ErrReplayNotFound    = errors.New("replay_not_found")
+	ErrReplayNotFinished = errors.New("replay_not_finished")
+)
+
+//GameReplay is the recorded run of a player, a base64 encoded gzip of the
+//inputs with their timestamps. The format of the inputs is up to the client.
+type GameReplay struct {
+	BaseModel
+
+	GameRefer uint   `json:"gameId" gorm:"unique_index:idx_game_replay"`
+	UserRefer uint   `json:"userId" gorm:"unique_index:idx_game_replay"`
+	Size      int    `json:"size"`
+	Data      string `json:"replay" sql:"type:mediumtext"`
+}
+
+//ValidateReplay checks the encoding and the size limits of a replay
+func ValidateReplay(replay string) error {
+	settings := config.Cfg.ReplaySettings
+
+	data, err := base64.StdEncoding.DecodeString(replay)
+	if err != nil {
+		return ErrReplayInvalid
+	}
+	if settings.MaxSizeBytes > 0 && len(data) > settings.MaxSizeBytes {
+		return ErrReplayTooLarge
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return ErrReplayInvalid
+	}
+	defer reader.Close()
+
+	// read one byte more than allowed to notice replays which inflate too much
+	var limited io.Reader = reader
+	if settings.MaxUncompressedBytes > 0 {
+		limited = io.LimitReader(reader, int64(settings.MaxUncompressedBytes)+1)
+	}
+	uncompressed, err := ioutil.ReadAll(limited)
+	if err != nil {
+		return ErrReplayInvalid
+	}
+	if settings.MaxUncompressedBytes > 0 && len(uncompressed) > settings.MaxUncompressedBytes {
+		return ErrReplayTooLarge
+	}
+
+	return nil
+}
+
+//SaveReplay stores the replay of a player of the game
+func (game *Game) SaveReplay(userID uint, replay string) error {
+	if err := ValidateReplay(replay); err != nil {
+		return err
+	}
+
+	gameReplay := GameReplay{
+		GameRefer: game.ID,
+		UserRefer: userID,
+		Size:      len(replay),
+		Data:      replay,
+	}
+
+	db := GetDatabaseSession()
+	return db.Create(&gameReplay).Error
+}
+
+//FindOpponentReplay returns the replay of the other player. It is only handed
+//out once the user finished, so nobody can watch it before playing.
+func (game *Game) FindOpponentReplay(userID uint) (GameReplay, error) {
+	var finished bool
+	var opponentID uint
+	switch userID {
+	case game.CreatorRefer:
+		finished = game.StateCreator == GameStateCompleted
+		opponentID = game.OpponentRefer
+	case game.OpponentRefer:
+		finished = game.StateOpponent == GameStateCompleted
+		opponentID = game.CreatorRefer
+	default:
+		return GameReplay{}, ErrReplayNotFound
+	}
+
+	if !finished {
+		return GameReplay{}, ErrReplayNotFinished
+	}
+
+	db := GetDatabaseSession()
+
+	var gameReplay GameReplay
+	db.Where("game_refer = ? AND user_refer = ?", game.ID, opponentID).First(&gameReplay)
+	if gameReplay.ID == 0 {
+		return GameReplay{}, ErrReplayNotFound
+	}
+	return gameReplay, nil
+}
+
+//CleanUp removes the replays older than the retention period
+func (gameReplay GameReplay) CleanUp() {
+	retentionDays := config.Cfg.ReplaySettings.RetentionDays
+	if retentionDays <= 0 {
+		return
+	}
+
+	db := GetDatabaseSession()
+	db.Unscoped().Where("created_at < ?", time.Now().AddDate(0, 0, -retentionDays)).Delete(&GameReplay{})
+}
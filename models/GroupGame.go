@@ -0,0 +1,423 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"timedrop/config"
+)
+
+var (
+	ParticipantStateInvited   = "invited"
+	ParticipantStateJoined    = "joined"
+	ParticipantStateStarted   = "started"
+	ParticipantStateCompleted = "completed"
+	ParticipantStateExpired   = "expired"
+
+	CoinReasonGroupGame = "group_game"
+)
+
+var (
+	ErrGroupGameNotFound      = errors.New("group_game_not_found")
+	ErrGroupGameFull          = errors.New("group_game_full")
+	ErrGroupGameEnded         = errors.New("group_game_ended")
+	ErrGroupGameNotFriends    = errors.New("group_game_not_friends")
+	ErrGroupGameNotJoined     = errors.New("group_game_not_joined")
+	ErrGroupGameAlreadyJoined = errors.New("group_game_already_joined")
+)
+
+//GroupGame is a challenge in which several friends play the same map and
+//mode, each on their own time within the window
+type GroupGame struct {
+	BaseModel
+
+	CreatorRefer    uint      `json:"creatorId" sql:"index"`
+	Type            string    `json:"type"`
+	MapID           int       `json:"mapId"`
+	MaxParticipants int       `json:"maxParticipants"`
+	EndsAt          time.Time `json:"endsAt"`
+	Completed       bool      `json:"completed"`
+
+	Participants []GameParticipant `json:"participants" gorm:"-"`
+}
+
+//GameParticipant is the state and result of one player of a group game
+type GameParticipant struct {
+	BaseModel
+
+	GroupGameRefer uint       `json:"groupGameId" gorm:"unique_index:idx_game_participant"`
+	UserRefer      uint       `json:"userId" gorm:"unique_index:idx_game_participant"`
+	State          string     `json:"state"`
+	Score          int        `json:"score"`
+	StartTime      *time.Time `json:"startTime"`
+	Rank           int        `json:"rank"`
+	RewardCoins    int        `json:"rewardCoins"`
+
+	User User `json:"user" gorm:"-"`
+}
+
+//CreateGroupGame creates a group game of the user and invites the friends
+func (user *User) CreateGroupGame(friendIDs []uint) (GroupGame, error) {
+	settings := config.Cfg.GroupGameSettings
+	if len(friendIDs)+1 > settings.MaxParticipants {
+		return GroupGame{}, ErrGroupGameFull
+	}
+
+	var friend Friend
+	for _, friendID := range friendIDs {
+		if friendID == user.ID || !friend.IsAlreadyFriendsWith(user.ID, friendID) {
+			return GroupGame{}, ErrGroupGameNotFriends
+		}
+	}
+
+	// everybody plays against everybody, so no two players may have blocked
+	// each other
+	var block Block
+	playerIDs := append([]uint{user.ID}, friendIDs...)
+	for i := range playerIDs {
+		for _, otherID := range playerIDs[i+1:] {
+			if block.IsBlockedBetween(playerIDs[i], otherID) {
+				return GroupGame{}, ErrUserBlocked
+			}
+		}
+	}
+
+	groupGame := GroupGame{
+		CreatorRefer:    user.ID,
+		MaxParticipants: settings.MaxParticipants,
+		EndsAt:          time.Now().Add(time.Duration(settings.WindowHours) * time.Hour),
+	}
+
+	var game Game
+	game.SetRandomGameType()
+	game.SetRandomMapID()
+	groupGame.Type = game.Type
+	groupGame.MapID = game.MapID
+
+	tx := GetDatabaseSession().Begin()
+	if result := tx.Create(&groupGame); result.Error != nil {
+		tx.Rollback()
+		return GroupGame{}, result.Error
+	}
+
+	participants := []GameParticipant{{
+		GroupGameRefer: groupGame.ID,
+		UserRefer:      user.ID,
+		State:          ParticipantStateJoined,
+	}}
+	for _, friendID := range friendIDs {
+		participants = append(participants, GameParticipant{
+			GroupGameRefer: groupGame.ID,
+			UserRefer:      friendID,
+			State:          ParticipantStateInvited,
+		})
+	}
+
+	for i := range participants {
+		// the unique index also rejects friends invited twice
+		if result := tx.Create(&participants[i]); result.Error != nil {
+			tx.Rollback()
+			return GroupGame{}, result.Error
+		}
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return GroupGame{}, result.Error
+	}
+
+	for _, friendID := range friendIDs {
+		var receiver User
+		if err := receiver.FindByID(friendID); err == nil {
+			var pushNotification PushNotification
+			go pushNotification.SendGameRequestPush(receiver)
+		}
+	}
+
+	groupGame.Participants = participants
+	return groupGame, nil
+}
+
+//FindByID loads the group game with its participants
+func (groupGame *GroupGame) FindByID(groupGameID interface{}) error {
+	db := GetDatabaseSession()
+
+	if result := db.First(&groupGame, groupGameID); result.Error != nil {
+		return ErrGroupGameNotFound
+	}
+
+	var participants []GameParticipant
+	if result := db.Where("group_game_refer = ?", groupGame.ID).Find(&participants); result.Error != nil {
+		return result.Error
+	}
+	sortParticipants(participants)
+
+	for i := range participants {
+		participants[i].User.FindByID(participants[i].UserRefer)
+		participants[i].User.Email = ""
+	}
+	groupGame.Participants = participants
+
+	return nil
+}
+
+//FindByUserID lists the group games the user takes part in, newest first
+func (groupGame GroupGame) FindByUserID(userID interface{}, limit int) (groupGames []GroupGame, err error) {
+	db := GetDatabaseSession()
+	result := db.Where("id IN (SELECT group_game_refer FROM game_participants WHERE user_refer = ? AND deleted_at IS NULL)", userID).
+		Order("id desc").Limit(limit).Find(&groupGames)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	for i := range groupGames {
+		var participants []GameParticipant
+		db.Where("group_game_refer = ?", groupGames[i].ID).Find(&participants)
+		sortParticipants(participants)
+		groupGames[i].Participants = participants
+	}
+	return groupGames, nil
+}
+
+//participant returns the participant entry of the user
+func (groupGame *GroupGame) participant(userID uint) (GameParticipant, bool) {
+	for _, participant := range groupGame.Participants {
+		if participant.UserRefer == userID {
+			return participant, true
+		}
+	}
+	return GameParticipant{}, false
+}
+
+//Join accepts the invitation of the user, friends of the creator can also
+//take a free place without an invitation
+func (groupGame *GroupGame) Join(user *User) (GameParticipant, error) {
+	db := GetDatabaseSession()
+
+	if groupGame.Completed || time.Now().After(groupGame.EndsAt) {
+		return GameParticipant{}, ErrGroupGameEnded
+	}
+
+	var block Block
+	for _, participant := range groupGame.Participants {
+		if participant.UserRefer != user.ID && block.IsBlockedBetween(user.ID, participant.UserRefer) {
+			return GameParticipant{}, ErrUserBlocked
+		}
+	}
+
+	if participant, ok := groupGame.participant(user.ID); ok {
+		if participant.State != ParticipantStateInvited {
+			return GameParticipant{}, ErrGroupGameAlreadyJoined
+		}
+		participant.State = ParticipantStateJoined
+		if result := db.Model(&participant).Update("state", ParticipantStateJoined); result.Error != nil {
+			return GameParticipant{}, result.Error
+		}
+		return participant, nil
+	}
+
+	var friend Friend
+	if !friend.IsAlreadyFriendsWith(user.ID, groupGame.CreatorRefer) {
+		return GameParticipant{}, ErrGroupGameNotFriends
+	}
+	if len(groupGame.Participants) >= groupGame.MaxParticipants {
+		return GameParticipant{}, ErrGroupGameFull
+	}
+
+	participant := GameParticipant{
+		GroupGameRefer: groupGame.ID,
+		UserRefer:      user.ID,
+		State:          ParticipantStateJoined,
+	}
+	if result := db.Create(&participant); result.Error != nil {
+		return GameParticipant{}, ErrGroupGameAlreadyJoined
+	}
+
+	// concurrent joins may have taken the last place
+	var count int
+	db.Model(&GameParticipant{}).Where("group_game_refer = ?", groupGame.ID).Count(&count)
+	if count > groupGame.MaxParticipants {
+		db.Unscoped().Delete(&participant)
+		return GameParticipant{}, ErrGroupGameFull
+	}
+
+	return participant, nil
+}
+
+//Start starts the play of the user, it takes a life like a regular game
+func (groupGame *GroupGame) Start(user *User) (GameParticipant, error) {
+	db := GetDatabaseSession()
+
+	if groupGame.Completed || time.Now().After(groupGame.EndsAt) {
+		return GameParticipant{}, ErrGroupGameEnded
+	}
+
+	participant, ok := groupGame.participant(user.ID)
+	if !ok || participant.State != ParticipantStateJoined {
+		return GameParticipant{}, ErrGroupGameNotJoined
+	}
+
+	if err := user.ConsumeLife(); err != nil {
+		return GameParticipant{}, err
+	}
+
+	now := time.Now()
+	result := db.Model(&GameParticipant{}).Where("id = ? AND state = ?", participant.ID, ParticipantStateJoined).
+		Updates(map[string]interface{}{"state": ParticipantStateStarted, "start_time": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		// the game never started, so the life is given back
+		user.AddLives(1)
+		if result.Error != nil {
+			return GameParticipant{}, result.Error
+		}
+		return GameParticipant{}, ErrGroupGameNotJoined
+	}
+
+	participant.State = ParticipantStateStarted
+	participant.StartTime = &now
+	return participant, nil
+}
+
+//SaveResult stores the score of the user and completes the group game once
+//everybody played
+func (groupGame *GroupGame) SaveResult(user *User, score int) (GameParticipant, error) {
+	db := GetDatabaseSession()
+
+	// results after the window would change a ranking which is already final
+	if groupGame.Completed || time.Now().After(groupGame.EndsAt) {
+		return GameParticipant{}, ErrGroupGameEnded
+	}
+
+	participant, ok := groupGame.participant(user.ID)
+	if !ok || participant.State != ParticipantStateStarted {
+		return GameParticipant{}, ErrGroupGameNotJoined
+	}
+
+	result := db.Model(&GameParticipant{}).Where("id = ? AND state = ?", participant.ID, ParticipantStateStarted).
+		Updates(map[string]interface{}{"state": ParticipantStateCompleted, "score": score})
+	if result.Error != nil {
+		return GameParticipant{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return GameParticipant{}, ErrGroupGameNotJoined
+	}
+
+	var open int
+	db.Model(&GameParticipant{}).Where("group_game_refer = ? AND state != ?", groupGame.ID, ParticipantStateCompleted).Count(&open)
+	if open == 0 {
+		if err := groupGame.complete(); err != nil {
+			return GameParticipant{}, err
+		}
+	}
+
+	if err := groupGame.FindByID(groupGame.ID); err != nil {
+		return GameParticipant{}, err
+	}
+	participant, _ = groupGame.participant(user.ID)
+	return participant, nil
+}
+
+//complete ranks the participants and splits the pot between them
+func (groupGame *GroupGame) complete() error {
+	tmpLog := userLogger.New("func", "GroupGame.complete")
+	db := GetDatabaseSession()
+
+	// only the call which flips the flag hands out the rewards
+	result := db.Exec("UPDATE group_games SET completed = 1, updated_at = NOW() WHERE id = ? AND completed = 0", groupGame.ID)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return nil
+	}
+
+	// whoever didn't finish in time is out
+	db.Exec("UPDATE game_participants SET state = ? WHERE group_game_refer = ? AND state != ?",
+		ParticipantStateExpired, groupGame.ID, ParticipantStateCompleted)
+
+	var participants []GameParticipant
+	if result := db.Where("group_game_refer = ? AND state = ?", groupGame.ID, ParticipantStateCompleted).Find(&participants); result.Error != nil {
+		return result.Error
+	}
+
+	rankParticipants(participants)
+	pot := config.Cfg.GroupGameSettings.CoinsPerParticipant * len(participants)
+	rewards := groupGameRewards(participants, pot)
+
+	for i, participant := range participants {
+		db.Model(&participant).Updates(map[string]interface{}{"rank": participant.Rank, "reward_coins": rewards[i]})
+
+		if rewards[i] <= 0 {
+			continue
+		}
+		var player User
+		if err := player.FindByID(participant.UserRefer); err != nil {
+			continue
+		}
+		idempotencyKey := fmt.Sprintf("%s:%d", CoinReasonGroupGame, groupGame.ID)
+		if _, err := player.GrantCoins(rewards[i], CoinReasonGroupGame, idempotencyKey); err != nil {
+			tmpLog.Error(fmt.Sprintf("couldn't grant group game coins to user '%d': %v", player.ID, err))
+		}
+	}
+
+	groupGame.Completed = true
+	return nil
+}
+
+//rankParticipants sets the rank by score, like in regular games the lower
+//score wins. Equal scores share a rank.
+func rankParticipants(participants []GameParticipant) {
+	sort.SliceStable(participants, func(i, j int) bool {
+		return participants[i].Score < participants[j].Score
+	})
+
+	for i := range participants {
+		if i > 0 && participants[i].Score == participants[i-1].Score {
+			participants[i].Rank = participants[i-1].Rank
+			continue
+		}
+		participants[i].Rank = i + 1
+	}
+}
+
+//groupGameRewards splits the pot proportionally to the placement, the first
+//of n players weighs n, the last one 1
+func groupGameRewards(participants []GameParticipant, pot int) []int {
+	rewards := make([]int, len(participants))
+
+	totalWeight := 0
+	for _, participant := range participants {
+		totalWeight += len(participants) - participant.Rank + 1
+	}
+	if totalWeight == 0 {
+		return rewards
+	}
+
+	for i, participant := range participants {
+		rewards[i] = pot * (len(participants) - participant.Rank + 1) / totalWeight
+	}
+	return rewards
+}
+
+//sortParticipants orders ranked participants first
+func sortParticipants(participants []GameParticipant) {
+	sort.SliceStable(participants, func(i, j int) bool {
+		if participants[i].Rank == 0 || participants[j].Rank == 0 {
+			return participants[i].Rank != 0
+		}
+		return participants[i].Rank < participants[j].Rank
+	})
+}
+
+//CleanUp completes the group games whose window ended
+func (groupGame GroupGame) CleanUp() {
+	db := GetDatabaseSession()
+
+	var groupGames []GroupGame
+	db.Where("completed = 0 AND ends_at <= ?", time.Now()).Find(&groupGames)
+
+	for _, expiredGroupGame := range groupGames {
+		expiredGroupGame.complete()
+	}
+}
@@ -0,0 +1,478 @@
+package models
+
+import (
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"timedrop/config"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	IdentityProviderApple    = "apple"
+	IdentityProviderGoogle   = "google"
+	IdentityProviderFacebook = "facebook"
+)
+
+var (
+	ErrInvalidIdentityToken          = errors.New("invalid_identity_token")
+	ErrIdentityUnavailable           = errors.New("identity_verification_unavailable")
+	ErrIdentityProviderUnknown       = errors.New("identity_provider_unknown")
+	ErrIdentityLinkedToOtherUser     = errors.New("identity_linked_to_other_user")
+	ErrIdentityProviderAlreadyLinked = errors.New("identity_provider_already_linked")
+	ErrIdentityNotLinked             = errors.New("identity_not_linked")
+	ErrCanNotUnlinkLastLogin         = errors.New("can_not_unlink_last_login")
+	ErrIdentityLinkRequired          = errors.New("identity_link_required")
+	ErrUserNotFound                  = errors.New("user_not_found")
+)
+
+//VerifiedIdentity is what a provider confirmed about the owner of a token
+type VerifiedIdentity struct {
+	Provider string
+	// Subject is the stable user id at the provider
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+//IdentityProvider verifies the tokens a client got from a sign in provider
+type IdentityProvider interface {
+	Verify(token string) (VerifiedIdentity, error)
+}
+
+//IdentityProviders holds the verifier used for each provider
+var IdentityProviders = map[string]IdentityProvider{}
+
+var identityHTTPClient = &http.Client{Timeout: 10 * time.Second}
+
+//UserIdentity links an account at a sign in provider to a user, a user can
+//have one identity per provider
+type UserIdentity struct {
+	BaseModel
+
+	UserRefer uint   `json:"userId" sql:"index"`
+	Provider  string `json:"provider" gorm:"unique_index:idx_identity"`
+	Subject   string `json:"-" gorm:"unique_index:idx_identity"`
+	Email     string `json:"email"`
+}
+
+//InitIdentityProviders sets up the verifiers from the identity settings
+func InitIdentityProviders() {
+	settings := config.Cfg.IdentitySettings
+	if settings.UseFakeProviders {
+		for _, provider := range []string{IdentityProviderApple, IdentityProviderGoogle, IdentityProviderFacebook} {
+			IdentityProviders[provider] = FakeIdentityProvider{Provider: provider}
+		}
+		return
+	}
+
+	cacheDuration := time.Duration(settings.JWKSCacheMinutes) * time.Minute
+	IdentityProviders[IdentityProviderApple] = &OIDCIdentityProvider{
+		Provider:      IdentityProviderApple,
+		Issuers:       []string{"https://appleid.apple.com"},
+		JWKSURL:       "https://appleid.apple.com/auth/keys",
+		ClientIDs:     settings.AppleClientIDs,
+		CacheDuration: cacheDuration,
+	}
+	IdentityProviders[IdentityProviderGoogle] = &OIDCIdentityProvider{
+		Provider:      IdentityProviderGoogle,
+		Issuers:       []string{"https://accounts.google.com", "accounts.google.com"},
+		JWKSURL:       "https://www.googleapis.com/oauth2/v3/certs",
+		ClientIDs:     settings.GoogleClientIDs,
+		CacheDuration: cacheDuration,
+	}
+	IdentityProviders[IdentityProviderFacebook] = FacebookIdentityProvider{
+		// the ID tokens of Limited Login
+		IDTokens: &OIDCIdentityProvider{
+			Provider:      IdentityProviderFacebook,
+			Issuers:       []string{"https://www.facebook.com"},
+			JWKSURL:       "https://limited.facebook.com/.well-known/oauth/openid/jwks/",
+			ClientIDs:     settings.FacebookAppIDs,
+			CacheDuration: cacheDuration,
+		},
+		AccessTokens: FacebookGraphProvider{
+			GraphURL:  settings.FacebookGraphURL,
+			AppIDs:    settings.FacebookAppIDs,
+			AppSecret: settings.FacebookAppSecret,
+		},
+	}
+}
+
+//VerifyIdentity checks the token with the provider it was issued by
+func VerifyIdentity(provider, token string) (VerifiedIdentity, error) {
+	identityProvider, ok := IdentityProviders[provider]
+	if !ok {
+		return VerifiedIdentity{}, ErrIdentityProviderUnknown
+	}
+	return identityProvider.Verify(token)
+}
+
+//FakeIdentityProvider accepts tokens in the form "fake:<subject>" or
+//"fake:<subject>:<email>" for local development and tests
+type FakeIdentityProvider struct {
+	Provider string
+}
+
+//Verify a fake token
+func (provider FakeIdentityProvider) Verify(token string) (VerifiedIdentity, error) {
+	parts := strings.SplitN(token, ":", 3)
+	if len(parts) < 2 || parts[0] != "fake" || parts[1] == "" {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	identity := VerifiedIdentity{
+		Provider: provider.Provider,
+		Subject:  parts[1],
+	}
+	if len(parts) == 3 {
+		identity.Email = parts[2]
+		identity.EmailVerified = true
+	}
+	return identity, nil
+}
+
+//OIDCIdentityProvider verifies OpenID Connect ID tokens with the public keys
+//the provider publishes, the keys are cached
+type OIDCIdentityProvider struct {
+	Provider      string
+	Issuers       []string
+	JWKSURL       string
+	ClientIDs     []string
+	CacheDuration time.Duration
+
+	mutex     sync.Mutex
+	keys      map[string]*rsa.PublicKey
+	fetchedAt time.Time
+}
+
+type jsonWebKeySet struct {
+	Keys []struct {
+		Kid string `json:"kid"`
+		Kty string `json:"kty"`
+		N   string `json:"n"`
+		E   string `json:"e"`
+	} `json:"keys"`
+}
+
+//Verify an ID token
+func (provider *OIDCIdentityProvider) Verify(token string) (VerifiedIdentity, error) {
+	var keyErr error
+	parsed, err := jwt.Parse(token, func(parsed *jwt.Token) (interface{}, error) {
+		if _, ok := parsed.Method.(*jwt.SigningMethodRSA); !ok {
+			return nil, ErrInvalidIdentityToken
+		}
+		kid, _ := parsed.Header["kid"].(string)
+		key, err := provider.getKey(kid)
+		keyErr = err
+		return key, err
+	})
+	if keyErr == ErrIdentityUnavailable {
+		return VerifiedIdentity{}, ErrIdentityUnavailable
+	}
+	if err != nil || parsed == nil || !parsed.Valid {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	issuer, _ := claims["iss"].(string)
+	if !containsString(provider.Issuers, issuer) || !provider.hasAudience(claims["aud"]) {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	subject, _ := claims["sub"].(string)
+	if subject == "" {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	// MapClaims.Valid skips exp and iat when they are missing, a token
+	// without them would never expire
+	if !hasNumericClaim(claims, "exp") || !hasNumericClaim(claims, "iat") {
+		return VerifiedIdentity{}, ErrInvalidIdentityToken
+	}
+
+	identity := VerifiedIdentity{
+		Provider: provider.Provider,
+		Subject:  subject,
+	}
+	identity.Email, _ = claims["email"].(string)
+	identity.Name, _ = claims["name"].(string)
+	// Apple sends the flag as a string
+	switch emailVerified := claims["email_verified"].(type) {
+	case bool:
+		identity.EmailVerified = emailVerified
+	case string:
+		identity.EmailVerified = emailVerified == "true"
+	}
+
+	return identity, nil
+}
+
+//hasNumericClaim checks the claim is set to a number, e.g. a timestamp
+func hasNumericClaim(claims jwt.MapClaims, name string) bool {
+	switch claims[name].(type) {
+	case float64, json.Number:
+		return true
+	}
+	return false
+}
+
+//hasAudience checks the token was issued for one of our apps, aud is either
+//a string or a list
+func (provider *OIDCIdentityProvider) hasAudience(audience interface{}) bool {
+	switch audience := audience.(type) {
+	case string:
+		return containsString(provider.ClientIDs, audience)
+	case []interface{}:
+		for _, entry := range audience {
+			if entry, ok := entry.(string); ok && containsString(provider.ClientIDs, entry) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//getKey returns the public key with the id, the key set is fetched again when
+//the cache expired or the key is unknown, e.g. after a key rotation
+func (provider *OIDCIdentityProvider) getKey(kid string) (*rsa.PublicKey, error) {
+	provider.mutex.Lock()
+	defer provider.mutex.Unlock()
+
+	now := time.Now()
+	if key, ok := provider.keys[kid]; ok && now.Sub(provider.fetchedAt) < provider.CacheDuration {
+		return key, nil
+	}
+
+	// don't let unknown key ids hammer the provider
+	if provider.keys != nil && now.Sub(provider.fetchedAt) < time.Minute {
+		if key, ok := provider.keys[kid]; ok {
+			return key, nil
+		}
+		return nil, ErrInvalidIdentityToken
+	}
+
+	keys, err := fetchJSONWebKeys(provider.JWKSURL)
+	if err != nil {
+		return nil, ErrIdentityUnavailable
+	}
+	provider.keys = keys
+	provider.fetchedAt = now
+
+	key, ok := keys[kid]
+	if !ok {
+		return nil, ErrInvalidIdentityToken
+	}
+	return key, nil
+}
+
+//fetchJSONWebKeys loads the RSA keys of a JWKS endpoint by key id
+func fetchJSONWebKeys(jwksURL string) (map[string]*rsa.PublicKey, error) {
+	res, err := identityHTTPClient.Get(jwksURL)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return nil, fmt.Errorf("jwks endpoint answered %d", res.StatusCode)
+	}
+
+	var keySet jsonWebKeySet
+	if err := json.NewDecoder(res.Body).Decode(&keySet); err != nil {
+		return nil, err
+	}
+
+	keys := make(map[string]*rsa.PublicKey)
+	for _, key := range keySet.Keys {
+		if key.Kty != "RSA" {
+			continue
+		}
+		n, err := base64.RawURLEncoding.DecodeString(key.N)
+		if err != nil {
+			continue
+		}
+		e, err := base64.RawURLEncoding.DecodeString(key.E)
+		if err != nil {
+			continue
+		}
+		keys[key.Kid] = &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+	}
+	return keys, nil
+}
+
+func containsString(values []string, value string) bool {
+	for _, entry := range values {
+		if entry == value && value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+//FindIdentities lists the sign in providers linked to the user
+func (user *User) FindIdentities() (identities []UserIdentity, err error) {
+	db := GetDatabaseSession()
+	result := db.Where("user_refer = ?", user.ID).Order("id asc").Find(&identities)
+	return identities, result.Error
+}
+
+//LinkIdentity connects a verified identity to the user, linking the same
+//identity again succeeds
+func (user *User) LinkIdentity(identity VerifiedIdentity) (UserIdentity, error) {
+	db := GetDatabaseSession()
+
+	var userIdentity UserIdentity
+	db.Where("provider = ? AND subject = ?", identity.Provider, identity.Subject).First(&userIdentity)
+	if userIdentity.ID != 0 {
+		if userIdentity.UserRefer != user.ID {
+			return UserIdentity{}, ErrIdentityLinkedToOtherUser
+		}
+		return userIdentity, nil
+	}
+
+	var count int
+	db.Model(&UserIdentity{}).Where("user_refer = ? AND provider = ?", user.ID, identity.Provider).Count(&count)
+	if count > 0 {
+		return UserIdentity{}, ErrIdentityProviderAlreadyLinked
+	}
+
+	userIdentity = UserIdentity{
+		UserRefer: user.ID,
+		Provider:  identity.Provider,
+		Subject:   identity.Subject,
+		Email:     identity.Email,
+	}
+	// the unique index catches a concurrent link of the same identity
+	if result := db.Create(&userIdentity); result.Error != nil {
+		return UserIdentity{}, ErrIdentityLinkedToOtherUser
+	}
+
+	changed := false
+	if identity.Provider == IdentityProviderFacebook {
+		user.FacebookID = identity.Subject
+		user.IsVerified = true
+		if user.FbName == "" {
+			user.FbName = identity.Name
+		}
+		changed = true
+	}
+	// a confirmed address of the provider counts as verified
+	if user.Email == "" && identity.EmailVerified && user.IsEmailUnique(identity.Email) {
+		user.Email = identity.Email
+		user.MarkEmailVerified()
+		changed = true
+	}
+	if changed {
+		user.Guest = false
+		if err := user.Save(); err != nil {
+			return UserIdentity{}, err
+		}
+	}
+
+	return userIdentity, nil
+}
+
+//identityToUnlink returns the identity of the provider, as long as the user
+//keeps another identity or a verified email to log in with
+func (user *User) identityToUnlink(identities []UserIdentity, provider string) (UserIdentity, error) {
+	var userIdentity UserIdentity
+	for _, identity := range identities {
+		if identity.Provider == provider {
+			userIdentity = identity
+		}
+	}
+	if userIdentity.ID == 0 {
+		return UserIdentity{}, ErrIdentityNotLinked
+	}
+
+	hasVerifiedEmail := user.Email != "" && NormalizeEmail(user.Email) == NormalizeEmail(user.VerifiedEmail)
+	if len(identities) == 1 && !hasVerifiedEmail {
+		return UserIdentity{}, ErrCanNotUnlinkLastLogin
+	}
+	return userIdentity, nil
+}
+
+//UnlinkIdentity removes a provider from the user. The user has to keep a way
+//to sign in, another identity or a verified email for login codes.
+func (user *User) UnlinkIdentity(provider string) error {
+	db := GetDatabaseSession()
+
+	identities, err := user.FindIdentities()
+	if err != nil {
+		return err
+	}
+
+	userIdentity, err := user.identityToUnlink(identities, provider)
+	if err != nil {
+		return err
+	}
+
+	if result := db.Unscoped().Delete(&userIdentity); result.Error != nil {
+		return result.Error
+	}
+
+	if provider == IdentityProviderFacebook && user.FacebookID == userIdentity.Subject {
+		user.FacebookID = ""
+		return user.Save()
+	}
+	return nil
+}
+
+//LoginWithIdentity finds the user of a verified identity. Unknown identities
+//are connected to the user with the same verified email or get a new user.
+//A legacy Facebook ID is not enough to log in, its owner has to link Facebook
+//while logged in.
+func LoginWithIdentity(identity VerifiedIdentity) (user User, created bool, err error) {
+	db := GetDatabaseSession()
+
+	if user, err = FindUserByIdentity(identity); err == nil {
+		return user, false, nil
+	}
+	user = User{}
+
+	// only a user whose current email is the verified one is linked
+	if identity.EmailVerified && identity.Email != "" {
+		db.Where("verified_email = ?", NormalizeEmail(identity.Email)).First(&user)
+		if user.ID != 0 && NormalizeEmail(user.Email) != user.VerifiedEmail {
+			user = User{}
+		}
+	}
+
+	// the owner of the old account would end up with a second, empty one
+	if user.ID == 0 && hasLegacyFacebookID(identity) {
+		return User{}, false, ErrIdentityLinkRequired
+	}
+
+	if user.ID == 0 {
+		user = User{
+			Username: GetGuestUsername(0),
+			Score:    100,
+		}
+		if err := user.Save(); err != nil {
+			return User{}, false, err
+		}
+		created = true
+	}
+
+	if _, err := user.LinkIdentity(identity); err != nil {
+		return User{}, false, err
+	}
+	return user, created, nil
+}
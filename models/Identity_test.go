@@ -0,0 +1,272 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+const (
+	testIssuer   = "https://accounts.example.com"
+	testClientID = "timedrop-test"
+)
+
+//testJWKSServer serves the public keys of a key set and counts the fetches
+type testJWKSServer struct {
+	*httptest.Server
+
+	mutex   sync.Mutex
+	keys    map[string]*rsa.PrivateKey
+	fetches int
+	failing bool
+}
+
+func newTestJWKSServer(t *testing.T) *testJWKSServer {
+	jwks := &testJWKSServer{keys: map[string]*rsa.PrivateKey{}}
+	jwks.Server = httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		jwks.mutex.Lock()
+		defer jwks.mutex.Unlock()
+
+		jwks.fetches++
+		if jwks.failing {
+			res.WriteHeader(500)
+			return
+		}
+
+		var keySet jsonWebKeySet
+		for kid, key := range jwks.keys {
+			keySet.Keys = append(keySet.Keys, struct {
+				Kid string `json:"kid"`
+				Kty string `json:"kty"`
+				N   string `json:"n"`
+				E   string `json:"e"`
+			}{kid, "RSA",
+				base64.RawURLEncoding.EncodeToString(key.PublicKey.N.Bytes()),
+				base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.PublicKey.E)).Bytes())})
+		}
+		json.NewEncoder(res).Encode(keySet)
+	}))
+	return jwks
+}
+
+//addKey creates a key which the server publishes from now on
+func (jwks *testJWKSServer) addKey(t *testing.T, kid string) *rsa.PrivateKey {
+	key, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	jwks.mutex.Lock()
+	defer jwks.mutex.Unlock()
+	jwks.keys[kid] = key
+	return key
+}
+
+func (jwks *testJWKSServer) fetchCount() int {
+	jwks.mutex.Lock()
+	defer jwks.mutex.Unlock()
+	return jwks.fetches
+}
+
+func newTestOIDCProvider(jwksURL string) *OIDCIdentityProvider {
+	return &OIDCIdentityProvider{
+		Provider:      IdentityProviderGoogle,
+		Issuers:       []string{testIssuer},
+		JWKSURL:       jwksURL,
+		ClientIDs:     []string{testClientID},
+		CacheDuration: time.Hour,
+	}
+}
+
+func testClaims() jwt.MapClaims {
+	return jwt.MapClaims{
+		"iss":            testIssuer,
+		"aud":            testClientID,
+		"sub":            "subject-1",
+		"email":          "player@example.com",
+		"email_verified": true,
+		"exp":            time.Now().Add(time.Hour).Unix(),
+		"iat":            time.Now().Unix(),
+	}
+}
+
+func signTestToken(t *testing.T, key *rsa.PrivateKey, kid string, claims jwt.MapClaims) string {
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func TestOIDCIdentityProviderVerify(t *testing.T) {
+	jwks := newTestJWKSServer(t)
+	defer jwks.Close()
+	key := jwks.addKey(t, "key-1")
+	otherKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	withClaim := func(name string, value interface{}) jwt.MapClaims {
+		claims := testClaims()
+		if value == nil {
+			delete(claims, name)
+		} else {
+			claims[name] = value
+		}
+		return claims
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		want  error
+	}{
+		{"valid", signTestToken(t, key, "key-1", testClaims()), nil},
+		{"audience list", signTestToken(t, key, "key-1", withClaim("aud", []string{"other", testClientID})), nil},
+		{"other issuer", signTestToken(t, key, "key-1", withClaim("iss", "https://evil.example.com")), ErrInvalidIdentityToken},
+		{"other audience", signTestToken(t, key, "key-1", withClaim("aud", "other-app")), ErrInvalidIdentityToken},
+		{"expired", signTestToken(t, key, "key-1", withClaim("exp", time.Now().Add(-time.Minute).Unix())), ErrInvalidIdentityToken},
+		{"no subject", signTestToken(t, key, "key-1", withClaim("sub", nil)), ErrInvalidIdentityToken},
+		{"no expiry", signTestToken(t, key, "key-1", withClaim("exp", nil)), ErrInvalidIdentityToken},
+		{"no issued at", signTestToken(t, key, "key-1", withClaim("iat", nil)), ErrInvalidIdentityToken},
+		{"expiry as string", signTestToken(t, key, "key-1", withClaim("exp", "2099-01-01")), ErrInvalidIdentityToken},
+		{"signed by another key", signTestToken(t, otherKey, "key-1", testClaims()), ErrInvalidIdentityToken},
+		{"not a token", "not.a.token", ErrInvalidIdentityToken},
+	}
+
+	provider := newTestOIDCProvider(jwks.URL)
+	for _, test := range tests {
+		if _, err := provider.Verify(test.token); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+
+	identity, err := provider.Verify(signTestToken(t, key, "key-1", withClaim("email_verified", "true")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := VerifiedIdentity{Provider: IdentityProviderGoogle, Subject: "subject-1", Email: "player@example.com", EmailVerified: true}
+	if identity != want {
+		t.Errorf("got %+v, want %+v", identity, want)
+	}
+
+	if fetches := jwks.fetchCount(); fetches != 1 {
+		t.Errorf("the key set was fetched %d times, want it cached after the first fetch", fetches)
+	}
+}
+
+func TestOIDCIdentityProviderUnknownKey(t *testing.T) {
+	jwks := newTestJWKSServer(t)
+	defer jwks.Close()
+	key := jwks.addKey(t, "key-1")
+
+	provider := newTestOIDCProvider(jwks.URL)
+	if _, err := provider.Verify(signTestToken(t, key, "key-1", testClaims())); err != nil {
+		t.Fatal(err)
+	}
+
+	// a rotated key shows up, but unknown key ids only refetch once a minute
+	rotatedKey := jwks.addKey(t, "key-2")
+	rotatedToken := signTestToken(t, rotatedKey, "key-2", testClaims())
+	for i := 0; i < 3; i++ {
+		if _, err := provider.Verify(rotatedToken); err != ErrInvalidIdentityToken {
+			t.Errorf("got %v, want %v within the minute", err, ErrInvalidIdentityToken)
+		}
+	}
+	if fetches := jwks.fetchCount(); fetches != 1 {
+		t.Errorf("the key set was fetched %d times, want 1", fetches)
+	}
+
+	provider.fetchedAt = time.Now().Add(-2 * time.Minute)
+	if _, err := provider.Verify(rotatedToken); err != nil {
+		t.Errorf("got %v after the minute, want the rotated key to be found", err)
+	}
+	if fetches := jwks.fetchCount(); fetches != 2 {
+		t.Errorf("the key set was fetched %d times, want 2", fetches)
+	}
+}
+
+func TestOIDCIdentityProviderUnavailable(t *testing.T) {
+	jwks := newTestJWKSServer(t)
+	defer jwks.Close()
+	key := jwks.addKey(t, "key-1")
+	jwks.failing = true
+
+	provider := newTestOIDCProvider(jwks.URL)
+	if _, err := provider.Verify(signTestToken(t, key, "key-1", testClaims())); err != ErrIdentityUnavailable {
+		t.Errorf("got %v, want %v", err, ErrIdentityUnavailable)
+	}
+}
+
+func TestFakeIdentityProvider(t *testing.T) {
+	provider := FakeIdentityProvider{Provider: IdentityProviderApple}
+
+	tests := []struct {
+		token string
+		want  VerifiedIdentity
+		err   error
+	}{
+		{"fake:subject-1", VerifiedIdentity{Provider: IdentityProviderApple, Subject: "subject-1"}, nil},
+		{"fake:subject-1:player@example.com", VerifiedIdentity{Provider: IdentityProviderApple, Subject: "subject-1",
+			Email: "player@example.com", EmailVerified: true}, nil},
+		{"fake:", VerifiedIdentity{}, ErrInvalidIdentityToken},
+		{"subject-1", VerifiedIdentity{}, ErrInvalidIdentityToken},
+		{"real:subject-1", VerifiedIdentity{}, ErrInvalidIdentityToken},
+	}
+
+	for _, test := range tests {
+		identity, err := provider.Verify(test.token)
+		if err != test.err || identity != test.want {
+			t.Errorf("%s: got %+v, %v, want %+v, %v", test.token, identity, err, test.want, test.err)
+		}
+	}
+}
+
+func TestMarkEmailVerified(t *testing.T) {
+	user := User{Email: " Player@Example.com"}
+	user.MarkEmailVerified()
+	if user.VerifiedEmail != "player@example.com" {
+		t.Errorf("got verified email %q, want it normalized", user.VerifiedEmail)
+	}
+}
+
+func TestIdentityToUnlink(t *testing.T) {
+	apple := UserIdentity{BaseModel: BaseModel{ID: 1}, Provider: IdentityProviderApple}
+	google := UserIdentity{BaseModel: BaseModel{ID: 2}, Provider: IdentityProviderGoogle}
+	verifiedUser := User{Email: "player@example.com", VerifiedEmail: "player@example.com"}
+	unverifiedUser := User{Email: "player@example.com"}
+
+	tests := []struct {
+		name       string
+		user       User
+		identities []UserIdentity
+		provider   string
+		want       uint
+		err        error
+	}{
+		{"another identity is left", unverifiedUser, []UserIdentity{apple, google}, IdentityProviderApple, apple.ID, nil},
+		{"the verified email is left", verifiedUser, []UserIdentity{apple}, IdentityProviderApple, apple.ID, nil},
+		{"last login", unverifiedUser, []UserIdentity{apple}, IdentityProviderApple, 0, ErrCanNotUnlinkLastLogin},
+		{"guest without email", User{}, []UserIdentity{google}, IdentityProviderGoogle, 0, ErrCanNotUnlinkLastLogin},
+		{"not linked", verifiedUser, []UserIdentity{apple}, IdentityProviderGoogle, 0, ErrIdentityNotLinked},
+	}
+
+	for _, test := range tests {
+		identity, err := test.user.identityToUnlink(test.identities, test.provider)
+		if err != test.err || identity.ID != test.want {
+			t.Errorf("%s: got identity %d, %v, want %d, %v", test.name, identity.ID, err, test.want, test.err)
+		}
+	}
+}
@@ -1,5 +1,13 @@
 package models
 
+import (
+	"fmt"
+
+	"timedrop/config"
+
+	"github.com/jinzhu/gorm"
+)
+
 // Level 's
 type Level struct {
 	BaseModel
@@ -8,74 +16,101 @@ type Level struct {
 	ToScore   int    `json:"toScore"`
 	Name      string `json:"name" gorm:";unique_index"`
 	Order     int    `json:"order" gorm:";unique_index"`
+
+	PromotionCoins  int `json:"promotionCoins"`
+	ProtectionGames int `json:"protectionGames"`
 }
 
-//Bootstrap given levels
+//Bootstrap syncs the configured levels into the levels table
 func (level Level) Bootstrap() {
-	db := GetDatabaseSession()
-	novice := Level{
-		Name:      "Novice",
-		FromScore: 0,
-		ToScore:   399,
-		Order:     1,
+	if err := level.Sync(config.Levels); err != nil {
+		panic("Error syncing levels\nerror: " + err.Error())
 	}
-	db.Save(&novice)
+}
 
-	greenhorn := Level{
-		Name:      "Greenhorn",
-		FromScore: novice.ToScore + 1,
-		ToScore:   799,
-		Order:     novice.Order + 1,
+//Sync stores the given level definitions, matched by their order, within one
+//transaction. Running it again with the same definitions doesn't change
+//anything. Levels users are still on can't be removed.
+func (level Level) Sync(definitions []config.LevelDefinition) error {
+	tx := GetDatabaseSession().Begin()
+	if err := level.sync(tx, definitions); err != nil {
+		tx.Rollback()
+		return err
 	}
-	db.Save(&greenhorn)
+	return tx.Commit().Error
+}
 
-	export := Level{
-		Name:      "Expert",
-		FromScore: greenhorn.ToScore + 1,
-		ToScore:   1099,
-		Order:     greenhorn.Order + 1,
+func (level Level) sync(tx *gorm.DB, definitions []config.LevelDefinition) error {
+	var orders []int
+	for _, definition := range definitions {
+		orders = append(orders, definition.Order)
 	}
-	db.Save(&export)
 
-	master := Level{
-		Name:      "Master",
-		FromScore: export.ToScore + 1,
-		ToScore:   1399,
-		Order:     export.Order + 1,
+	// removed levels are deleted for good, otherwise their name and order
+	// would still be taken by the unique indexes
+	if len(orders) > 0 {
+		var removedIDs []uint
+		if result := tx.Unscoped().Model(&Level{}).Where("`order` NOT IN (?)", orders).Pluck("id", &removedIDs); result.Error != nil {
+			return result.Error
+		}
+		if len(removedIDs) > 0 {
+			var users int
+			tx.Model(&User{}).Unscoped().Where("level_refer IN (?) OR top_level_refer IN (?)", removedIDs, removedIDs).Count(&users)
+			if users > 0 {
+				return fmt.Errorf("levels %v can't be removed, %d users are still on them", removedIDs, users)
+			}
+			if result := tx.Unscoped().Where("id IN (?)", removedIDs).Delete(&Level{}); result.Error != nil {
+				return result.Error
+			}
+		}
 	}
-	db.Save(&master)
 
-	grandMaster := Level{
-		Name:      "Grand Master",
-		FromScore: master.ToScore + 1,
-		ToScore:   1699,
-		Order:     master.Order + 1,
-	}
-	db.Save(&grandMaster)
+	existingLevels := map[int]Level{}
+	for _, definition := range definitions {
+		var existing Level
+		tx.Unscoped().Where("`order` = ?", definition.Order).First(&existing)
+		existingLevels[definition.Order] = existing
 
-	legend := Level{
-		Name:      "Legend",
-		FromScore: grandMaster.ToScore + 1,
-		ToScore:   1999,
-		Order:     grandMaster.Order + 1,
+		// renamed levels get a placeholder first, so two levels can swap names
+		if existing.ID != 0 && existing.Name != definition.Name {
+			if result := tx.Unscoped().Model(&existing).UpdateColumn("name", fmt.Sprintf("~%d", existing.ID)); result.Error != nil {
+				return result.Error
+			}
+		}
 	}
-	db.Save(&legend)
 
-	divine := Level{
-		Name:      "Divine",
-		FromScore: grandMaster.ToScore + 1,
-		ToScore:   2999,
-		Order:     legend.Order + 1,
-	}
-	db.Save(&divine)
+	for _, definition := range definitions {
+		existing := existingLevels[definition.Order]
+		if existing.ID != 0 &&
+			existing.DeletedAt == nil &&
+			existing.Name == definition.Name &&
+			existing.FromScore == definition.FromScore &&
+			existing.ToScore == definition.ToScore &&
+			existing.PromotionCoins == definition.PromotionCoins &&
+			existing.ProtectionGames == definition.ProtectionGames {
+			continue
+		}
 
-	splasher := Level{
-		Name:      "Splasher",
-		FromScore: grandMaster.ToScore + 1,
-		ToScore:   9999999,
-		Order:     divine.Order + 1,
+		existing.DeletedAt = nil
+		existing.Name = definition.Name
+		existing.Order = definition.Order
+		existing.FromScore = definition.FromScore
+		existing.ToScore = definition.ToScore
+		existing.PromotionCoins = definition.PromotionCoins
+		existing.ProtectionGames = definition.ProtectionGames
+		if result := tx.Unscoped().Save(&existing); result.Error != nil {
+			return result.Error
+		}
 	}
-	db.Save(&splasher)
+
+	return nil
+}
+
+//FindAll returns all levels sorted by their order
+func (level Level) FindAll() (levels []Level, err error) {
+	db := GetDatabaseSession()
+	result := db.Order("`order` asc").Find(&levels)
+	return levels, result.Error
 }
 
 //FindByID finds a level by id
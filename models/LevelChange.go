@@ -0,0 +1,32 @@
+package models
+
+import "fmt"
+
+//LevelChange is emitted whenever a saved user ends up in a different level
+type LevelChange struct {
+	User      User
+	FromLevel Level
+	ToLevel   Level
+}
+
+var levelChangeListeners []func(LevelChange)
+
+//IsPromotion reports whether the user moved up
+func (change LevelChange) IsPromotion() bool {
+	return change.ToLevel.Order > change.FromLevel.Order
+}
+
+//OnLevelChange registers a listener which is called after a user got
+//promoted or demoted. Listeners run synchronously within User.Save.
+func OnLevelChange(listener func(LevelChange)) {
+	levelChangeListeners = append(levelChangeListeners, listener)
+}
+
+func emitLevelChange(change LevelChange) {
+	tmpLog := userLogger.New("func", "emitLevelChange")
+	tmpLog.Debug(fmt.Sprintf("user '%d' moved from level '%s' to '%s'", change.User.ID, change.FromLevel.Name, change.ToLevel.Name))
+
+	for _, listener := range levelChangeListeners {
+		listener(change)
+	}
+}
@@ -0,0 +1,56 @@
+package models
+
+//resolveLevel decides which level a user ends up in after a score change.
+//Promotions always go through. Demotions are held back while the user is
+//protected and never go further than maxLevelsBelowTop below the top level.
+func resolveLevel(scoreLevel, currentLevel, topLevel Level, levels []Level, gamesPlayed, protectedUntil, maxLevelsBelowTop int) Level {
+	if currentLevel.ID == 0 || scoreLevel.Order >= currentLevel.Order {
+		return scoreLevel
+	}
+
+	if gamesPlayed < protectedUntil {
+		return currentLevel
+	}
+
+	floorLevel := levelBelow(levels, topLevel, maxLevelsBelowTop)
+	if floorLevel.ID == 0 || scoreLevel.Order >= floorLevel.Order {
+		return scoreLevel
+	}
+
+	// users who are already below the floor don't get pushed up by it
+	if floorLevel.Order > currentLevel.Order {
+		return currentLevel
+	}
+
+	return floorLevel
+}
+
+//levelBelow returns the level which is steps levels below the given one.
+//levels have to be sorted by their order.
+func levelBelow(levels []Level, level Level, steps int) Level {
+	for i, candidate := range levels {
+		if candidate.ID != level.ID {
+			continue
+		}
+
+		i -= steps
+		if i < 0 {
+			i = 0
+		}
+		return levels[i]
+	}
+
+	return Level{}
+}
+
+//promotionCoins sums up the rewards of all levels above fromLevel up to and
+//including toLevel, so skipping a level doesn't skip its reward
+func promotionCoins(levels []Level, fromLevel, toLevel Level) int {
+	coins := 0
+	for _, level := range levels {
+		if level.Order > fromLevel.Order && level.Order <= toLevel.Order {
+			coins += level.PromotionCoins
+		}
+	}
+	return coins
+}
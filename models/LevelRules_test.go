@@ -0,0 +1,91 @@
+package models
+
+import "testing"
+
+func testLevels() []Level {
+	levels := []Level{}
+	for i, coins := range []int{0, 10, 20, 30, 40, 50} {
+		levels = append(levels, Level{
+			BaseModel:       BaseModel{ID: uint(i + 1)},
+			Name:            string(rune('A' + i)),
+			Order:           i,
+			PromotionCoins:  coins,
+			ProtectionGames: 5,
+		})
+	}
+	return levels
+}
+
+func TestLevelBelow(t *testing.T) {
+	levels := testLevels()
+
+	tests := []struct {
+		name  string
+		level Level
+		steps int
+		want  uint
+	}{
+		{"two below", levels[4], 2, levels[2].ID},
+		{"clamped at the lowest level", levels[1], 3, levels[0].ID},
+		{"no steps", levels[3], 0, levels[3].ID},
+		{"unknown level", Level{BaseModel: BaseModel{ID: 99}}, 1, 0},
+	}
+
+	for _, test := range tests {
+		if got := levelBelow(levels, test.level, test.steps); got.ID != test.want {
+			t.Errorf("%s: got level %d, want %d", test.name, got.ID, test.want)
+		}
+	}
+}
+
+func TestPromotionCoins(t *testing.T) {
+	levels := testLevels()
+
+	tests := []struct {
+		name string
+		from Level
+		to   Level
+		want int
+	}{
+		{"single promotion", levels[1], levels[2], 20},
+		{"skipped levels are paid too", levels[1], levels[4], 20 + 30 + 40},
+		{"no promotion", levels[3], levels[3], 0},
+		{"demotion", levels[3], levels[1], 0},
+	}
+
+	for _, test := range tests {
+		if got := promotionCoins(levels, test.from, test.to); got != test.want {
+			t.Errorf("%s: got %d coins, want %d", test.name, got, test.want)
+		}
+	}
+}
+
+func TestResolveLevel(t *testing.T) {
+	levels := testLevels()
+
+	tests := []struct {
+		name           string
+		scoreLevel     Level
+		currentLevel   Level
+		topLevel       Level
+		gamesPlayed    int
+		protectedUntil int
+		maxBelowTop    int
+		want           uint
+	}{
+		{"new user gets the score level", levels[2], Level{}, Level{}, 0, 0, 2, levels[2].ID},
+		{"promotion", levels[3], levels[2], levels[2], 10, 0, 2, levels[3].ID},
+		{"protected user keeps the level", levels[1], levels[3], levels[3], 10, 12, 2, levels[3].ID},
+		{"demotion after the protection", levels[2], levels[3], levels[3], 12, 12, 2, levels[2].ID},
+		{"demotion stops at the floor", levels[0], levels[4], levels[4], 20, 0, 2, levels[2].ID},
+		{"user below the floor isn't pushed up", levels[0], levels[1], levels[5], 20, 0, 2, levels[1].ID},
+		{"demotion within the floor", levels[3], levels[4], levels[5], 20, 0, 2, levels[3].ID},
+	}
+
+	for _, test := range tests {
+		got := resolveLevel(test.scoreLevel, test.currentLevel, test.topLevel, levels, test.gamesPlayed, test.protectedUntil, test.maxBelowTop)
+		if got.ID != test.want {
+			t.Errorf("%s: got level %d, want %d", test.name, got.ID, test.want)
+		}
+	}
+}
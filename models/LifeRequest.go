@@ -1,16 +1,78 @@
 package models
 
+import (
+	"errors"
+	"time"
+
+	"timedrop/config"
+)
+
+var (
+	ErrLifeRequestNotFriends    = errors.New("life_request_not_friends")
+	ErrLifeRequestNotFound      = errors.New("life_request_not_found")
+	ErrLifeRequestCooldown      = errors.New("life_request_cooldown")
+	ErrLifeRequestSentLimit     = errors.New("life_request_sent_limit")
+	ErrLifeRequestReceiverLimit = errors.New("life_request_receiver_limit")
+	ErrLifeRequestGiftLimit     = errors.New("life_gift_limit")
+)
+
 //LifeRequest struct handels life_requests
 type LifeRequest struct {
 	BaseModel
 
-	RequesterRefer string `json:"requesterRefer"`
-	ReceiverRefer  string `json:"receiverRefer"`
+	RequesterRefer string     `json:"requesterRefer"`
+	ReceiverRefer  string     `json:"receiverRefer"`
+	Approved       string     `json:"-" sql:"default:'false'"`
+	Collected      string     `json:"-" sql:"default:'false'"`
+	ApprovedAt     *time.Time `json:"approvedAt"`
 }
 
-//AddUserFriends
-func (lr *LifeRequest) CreateLifeRecords(users []uint, userId uint) {
+//lifeRequestDayStart is the start of the day the daily limits count from
+func lifeRequestDayStart(now time.Time) time.Time {
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+}
+
+//checkLifeRequest applies the life request rules, lastRequestAt is the time
+//of the last request to the same receiver
+func checkLifeRequest(settings config.LifeRequestSettings, areFriends bool, sentToday, receivedToday int, lastRequestAt *time.Time, now time.Time) error {
+	if !areFriends {
+		return ErrLifeRequestNotFriends
+	}
+	if settings.MaxRequestsSentPerDay > 0 && sentToday >= settings.MaxRequestsSentPerDay {
+		return ErrLifeRequestSentLimit
+	}
+	if settings.MaxRequestsReceivedPerDay > 0 && receivedToday >= settings.MaxRequestsReceivedPerDay {
+		return ErrLifeRequestReceiverLimit
+	}
+	cooldown := time.Duration(settings.RequestCooldownMinutes) * time.Minute
+	if lastRequestAt != nil && now.Sub(*lastRequestAt) < cooldown {
+		return ErrLifeRequestCooldown
+	}
+	return nil
+}
+
+//checkLifeGift applies the life gift rules
+func checkLifeGift(settings config.LifeRequestSettings, hasOpenRequest, areFriends bool, giftsToday int) error {
+	if !hasOpenRequest {
+		return ErrLifeRequestNotFound
+	}
+	if !areFriends {
+		return ErrLifeRequestNotFriends
+	}
+	if settings.MaxGiftsPerDay > 0 && giftsToday >= settings.MaxGiftsPerDay {
+		return ErrLifeRequestGiftLimit
+	}
+	return nil
+}
+
+//CreateLifeRecords asks the given friends for a life. Receivers which
+//already have an open request are skipped, the ones the rules don't allow
+//are returned with the reason.
+func (lr *LifeRequest) CreateLifeRecords(users []uint, userId uint) (created []uint, rejected map[uint]error) {
 	db := GetDatabaseSession()
+	settings := config.Cfg.LifeRequestSettings
+	rejected = make(map[uint]error)
+
 	refers := make(map[uint]uint)
 	rows, _ := db.Raw(`
     SELECT receiver_refer
@@ -27,18 +89,51 @@ func (lr *LifeRequest) CreateLifeRecords(users []uint, userId uint) {
 	var requesterUserModel User
 	requesterUserModel.FindByID(userId)
 
+	now := time.Now()
+	dayStart := lifeRequestDayStart(now)
+
+	var sentToday int
+	db.Raw("SELECT COUNT(*) FROM life_requests WHERE requester_refer = ? AND created_at >= ?", userId, dayStart).Row().Scan(&sentToday)
+
 	for _, user := range users {
-		if refers[user] == 0 {
-			var push PushNotification
-			var userModel User
-			userModel.FindByID(user)
-			go push.SendLifeRequestPush(userModel, requesterUserModel.Username)
-			db.Exec(`
+		if refers[user] != 0 {
+			continue
+		}
+
+		var friend Friend
+		areFriends := user != userId && friend.IsAlreadyFriendsWith(userId, user)
+
+		var receivedToday int
+		db.Raw("SELECT COUNT(*) FROM life_requests WHERE receiver_refer = ? AND created_at >= ?", user, dayStart).Row().Scan(&receivedToday)
+
+		var lastRequestAt *time.Time
+		db.Raw("SELECT MAX(created_at) FROM life_requests WHERE requester_refer = ? AND receiver_refer = ?", userId, user).Row().Scan(&lastRequestAt)
+
+		if err := checkLifeRequest(settings, areFriends, sentToday, receivedToday, lastRequestAt, now); err != nil {
+			rejected[user] = err
+			continue
+		}
+
+		var block Block
+		if block.IsBlockedBetween(userId, user) {
+			rejected[user] = ErrUserBlocked
+			continue
+		}
+
+		var push PushNotification
+		var userModel User
+		userModel.FindByID(user)
+		go push.SendLifeRequestPush(userModel, requesterUserModel.Username)
+		db.Exec(`
       INSERT IGNORE INTO
         life_requests (requester_refer, receiver_refer, created_at)
       VALUES (?, ?, NOW())`, userId, user)
-		}
+
+		sentToday++
+		created = append(created, user)
 	}
+
+	return created, rejected
 }
 
 //AddUserFriends
@@ -66,24 +161,68 @@ func (lr *LifeRequest) GetAppovedRequests(userId uint) []int {
 		var user int
 		var id int
 		rows.Scan(&id, &user)
-		userIds = append(userIds, user)
-		db.Exec(`UPDATE life_requests SET collected = 'true' WHERE id = ?`, id)
+
+		// only the request which actually got marked gives a life, so
+		// parallel calls can't collect it twice
+		result := db.Exec(`UPDATE life_requests SET collected = 'true' WHERE id = ? AND collected = 'false'`, id)
+		if result.Error == nil && result.RowsAffected > 0 {
+			userIds = append(userIds, user)
+		}
+	}
+
+	if len(userIds) > 0 {
+		requester := User{}
+		requester.ID = userId
+		requester.AddLives(len(userIds))
 	}
 
 	return userIds
 }
 
-//GiveLife
-func (lr *LifeRequest) GiveLife(userIds []uint, receiverId uint) {
+//GiveLife approves the open life requests of the given requesters. Requests
+//the rules don't allow are returned with the reason.
+func (lr *LifeRequest) GiveLife(userIds []uint, receiverId uint) (given []uint, rejected map[uint]error) {
 	db := GetDatabaseSession()
+	settings := config.Cfg.LifeRequestSettings
+	rejected = make(map[uint]error)
+
+	var giftsToday int
+	db.Raw("SELECT COUNT(*) FROM life_requests WHERE receiver_refer = ? AND approved = 'true' AND approved_at >= ?", receiverId, lifeRequestDayStart(time.Now())).Row().Scan(&giftsToday)
+
 	for _, user := range userIds {
+		var openRequests int
+		db.Raw("SELECT COUNT(*) FROM life_requests WHERE requester_refer = ? AND receiver_refer = ? AND approved = 'false'", user, receiverId).Row().Scan(&openRequests)
+
+		var friend Friend
+		areFriends := friend.IsAlreadyFriendsWith(user, receiverId)
+
+		if err := checkLifeGift(settings, openRequests > 0, areFriends, giftsToday); err != nil {
+			rejected[user] = err
+			continue
+		}
+
+		var block Block
+		if block.IsBlockedBetween(user, receiverId) {
+			rejected[user] = ErrUserBlocked
+			continue
+		}
+
+		result := db.Exec("UPDATE life_requests SET approved = 'true', approved_at = NOW() WHERE requester_refer = ? AND receiver_refer = ? AND approved = 'false'", user, receiverId)
+		if result.Error != nil || result.RowsAffected == 0 {
+			rejected[user] = ErrLifeRequestNotFound
+			continue
+		}
+
 		var push PushNotification
 		var userModel User
 		userModel.FindByID(user)
 		go push.GiveLifeRequestPush(userModel)
+
+		giftsToday++
+		given = append(given, user)
 	}
 
-	db.Exec("UPDATE life_requests SET approved = 'true' WHERE requester_refer IN (?) AND receiver_refer = ? ", userIds, receiverId)
+	return given, rejected
 }
 
 //CreateInstallRequest
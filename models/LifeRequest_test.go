@@ -0,0 +1,78 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"timedrop/config"
+)
+
+var testLifeRequestSettings = config.LifeRequestSettings{
+	MaxRequestsSentPerDay:     5,
+	MaxRequestsReceivedPerDay: 3,
+	MaxGiftsPerDay:            4,
+	RequestCooldownMinutes:    60,
+}
+
+func TestCheckLifeRequest(t *testing.T) {
+	now := time.Date(2018, 3, 10, 15, 0, 0, 0, time.UTC)
+	recently := now.Add(-30 * time.Minute)
+	longAgo := now.Add(-2 * time.Hour)
+
+	tests := []struct {
+		name          string
+		settings      config.LifeRequestSettings
+		areFriends    bool
+		sentToday     int
+		receivedToday int
+		lastRequestAt *time.Time
+		want          error
+	}{
+		{"allowed", testLifeRequestSettings, true, 0, 0, nil, nil},
+		{"friends only", testLifeRequestSettings, false, 0, 0, nil, ErrLifeRequestNotFriends},
+		{"below the sent cap", testLifeRequestSettings, true, 4, 0, nil, nil},
+		{"sent cap reached", testLifeRequestSettings, true, 5, 0, nil, ErrLifeRequestSentLimit},
+		{"receiver cap reached", testLifeRequestSettings, true, 0, 3, nil, ErrLifeRequestReceiverLimit},
+		{"cooldown running", testLifeRequestSettings, true, 0, 0, &recently, ErrLifeRequestCooldown},
+		{"cooldown over", testLifeRequestSettings, true, 0, 0, &longAgo, nil},
+		{"no caps configured", config.LifeRequestSettings{}, true, 100, 100, &recently, nil},
+	}
+
+	for _, test := range tests {
+		err := checkLifeRequest(test.settings, test.areFriends, test.sentToday, test.receivedToday, test.lastRequestAt, now)
+		if err != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestCheckLifeGift(t *testing.T) {
+	tests := []struct {
+		name           string
+		settings       config.LifeRequestSettings
+		hasOpenRequest bool
+		areFriends     bool
+		giftsToday     int
+		want           error
+	}{
+		{"allowed", testLifeRequestSettings, true, true, 0, nil},
+		{"no open request", testLifeRequestSettings, false, true, 0, ErrLifeRequestNotFound},
+		{"friends only", testLifeRequestSettings, true, false, 0, ErrLifeRequestNotFriends},
+		{"gift cap reached", testLifeRequestSettings, true, true, 4, ErrLifeRequestGiftLimit},
+		{"no cap configured", config.LifeRequestSettings{}, true, true, 100, nil},
+	}
+
+	for _, test := range tests {
+		if err := checkLifeGift(test.settings, test.hasOpenRequest, test.areFriends, test.giftsToday); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
+
+func TestLifeRequestDayStart(t *testing.T) {
+	now := time.Date(2018, 3, 10, 23, 59, 0, 0, time.UTC)
+	want := time.Date(2018, 3, 10, 0, 0, 0, 0, time.UTC)
+	if got := lifeRequestDayStart(now); !got.Equal(want) {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,126 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"timedrop/config"
+)
+
+var ErrNoLivesLeft = errors.New("no_lives_left")
+
+//lifeRegenerationInterval is the time it takes to regenerate one life
+func lifeRegenerationInterval() time.Duration {
+	return time.Duration(config.Cfg.GameSettings.LifeRegenerationMinutes) * time.Minute
+}
+
+//regenerateLives returns the lives after regeneration and the time from
+//which the next life is counted. Lives above the max (e.g. gifted ones) are
+//kept, they just don't regenerate.
+func regenerateLives(lives int, since, now time.Time, maxLives int, interval time.Duration) (int, time.Time) {
+	if lives >= maxLives || interval <= 0 {
+		return lives, now
+	}
+
+	regenerated := int(now.Sub(since) / interval)
+	if regenerated <= 0 {
+		return lives, since
+	}
+
+	lives += regenerated
+	if lives >= maxLives {
+		return maxLives, now
+	}
+	return lives, since.Add(time.Duration(regenerated) * interval)
+}
+
+//setNextLifeAt fills the NextLifeAt field for the client
+func (user *User) setNextLifeAt() {
+	user.NextLifeAt = nil
+	if user.Lives < config.Cfg.GameSettings.MaxLives && user.LivesUpdatedAt != nil {
+		nextLifeAt := user.LivesUpdatedAt.Add(lifeRegenerationInterval())
+		user.NextLifeAt = &nextLifeAt
+	}
+}
+
+//RefreshLives applies the regeneration since the last change
+func (user *User) RefreshLives() error {
+	return user.updateLives(func(lives int) (int, error) {
+		return lives, nil
+	})
+}
+
+//ConsumeLife takes one life, e.g. when a game is started
+func (user *User) ConsumeLife() error {
+	return user.updateLives(func(lives int) (int, error) {
+		if lives < 1 {
+			return lives, ErrNoLivesLeft
+		}
+		return lives - 1, nil
+	})
+}
+
+//AddLives gives the user additional lives, these can exceed the max
+func (user *User) AddLives(count int) error {
+	return user.updateLives(func(lives int) (int, error) {
+		return lives + count, nil
+	})
+}
+
+//updateLives regenerates the lives of the locked user row, applies the change
+//and stores the result
+func (user *User) updateLives(change func(lives int) (int, error)) error {
+	tmpLog := userLogger.New("func", "updateLives")
+
+	tx := GetDatabaseSession().Begin()
+
+	var lockedUser User
+	if result := tx.Set("gorm:query_option", "FOR UPDATE").First(&lockedUser, user.ID); result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+
+	maxLives := config.Cfg.GameSettings.MaxLives
+
+	now := time.Now()
+	since := now
+	if lockedUser.LivesUpdatedAt != nil {
+		since = *lockedUser.LivesUpdatedAt
+	} else {
+		// users created before the server tracked lives start with all of them
+		lockedUser.Lives = maxLives
+	}
+
+	lives, since := regenerateLives(lockedUser.Lives, since, now, maxLives, lifeRegenerationInterval())
+
+	newLives, err := change(lives)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	// the regeneration timer starts once the user drops below the max
+	if lives >= maxLives && newLives < maxLives {
+		since = now
+	}
+
+	if result := tx.Exec("UPDATE users SET lives = ?, lives_updated_at = ? WHERE id = ?", newLives, since, user.ID); result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return result.Error
+	}
+
+	if newLives != lives {
+		tmpLog.Debug(fmt.Sprintf("lives of user '%d' changed from %d to %d", user.ID, lives, newLives))
+	}
+
+	user.Lives = newLives
+	user.LivesUpdatedAt = &since
+	user.setNextLifeAt()
+
+	return nil
+}
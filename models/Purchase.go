@@ -0,0 +1,154 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+
+	"timedrop/config"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+var (
+	PurchaseStateCompleted = "completed"
+	PurchaseStateRefunded  = "refunded"
+
+	CoinReasonPurchase = "purchase"
+)
+
+var (
+	ErrUnknownProduct     = errors.New("unknown_product")
+	ErrUnknownPlatform    = errors.New("unknown_platform")
+	ErrReceiptAlreadyUsed = errors.New("receipt_already_used")
+)
+
+// mysqlDuplicateEntry is the error number of a unique key violation
+const mysqlDuplicateEntry = 1062
+
+//Purchase records a redeemed store transaction
+type Purchase struct {
+	BaseModel
+
+	UserRefer     uint   `json:"userId" sql:"index"`
+	Platform      string `json:"platform" gorm:"unique_index:idx_purchase_transaction"`
+	TransactionID string `json:"transactionId" gorm:"unique_index:idx_purchase_transaction"`
+	ProductID     string `json:"productId"`
+	State         string `json:"state"`
+	// Sandbox purchases are test purchases, they are only accepted when
+	// the purchase settings allow it
+	Sandbox bool `json:"sandbox"`
+
+	Coins                int  `json:"coins"`
+	CoinTransactionRefer uint `json:"coinTransactionId"`
+
+	// the raw receipt is kept for support and refunds
+	Receipt string `json:"-" sql:"type:mediumtext"`
+}
+
+//FindByUserID lists the purchases of a user, newest first
+func (purchase Purchase) FindByUserID(userID interface{}) (purchases []Purchase, err error) {
+	db := GetDatabaseSession()
+	result := db.Where("user_refer = ?", userID).Order("id desc").Find(&purchases)
+	return purchases, result.Error
+}
+
+//findByTransaction finds an already redeemed store transaction
+func (purchase *Purchase) findByTransaction(platform, transactionID string) {
+	db := GetDatabaseSession()
+	db.Where("platform = ? AND transaction_id = ?", platform, transactionID).First(&purchase)
+}
+
+//RedeemPurchase verifies the receipt with the store and credits the coins of
+//the product. Redeeming the same receipt again returns the earlier purchase,
+//a receipt redeemed by another user is rejected.
+func (user *User) RedeemPurchase(platform, productID, receipt string) (Purchase, error) {
+	tmpLog := userLogger.New("func", "RedeemPurchase")
+
+	coins := config.Cfg.PurchaseSettings.CoinPacks[productID]
+	if coins <= 0 {
+		return Purchase{}, ErrUnknownProduct
+	}
+
+	verifier, ok := ReceiptVerifiers[platform]
+	if !ok {
+		return Purchase{}, ErrUnknownPlatform
+	}
+
+	verifiedPurchases, err := verifier.Verify(receipt, productID)
+	if err != nil {
+		tmpLog.Error(fmt.Sprintf("receipt of user '%d' for '%s' not verified: %v", user.ID, productID, err))
+		return Purchase{}, err
+	}
+
+	// an App Store receipt can contain several transactions of the same
+	// product, take the first one nobody redeemed yet
+	var redeemedPurchase Purchase
+	for _, verifiedPurchase := range verifiedPurchases {
+		var existing Purchase
+		existing.findByTransaction(platform, verifiedPurchase.TransactionID)
+		if existing.ID == 0 {
+			return user.createPurchase(platform, verifiedPurchase, coins, receipt)
+		}
+		if existing.UserRefer == user.ID && redeemedPurchase.ID == 0 {
+			redeemedPurchase = existing
+		}
+	}
+
+	if redeemedPurchase.ID != 0 {
+		return redeemedPurchase, nil
+	}
+
+	tmpLog.Error(fmt.Sprintf("user '%d' tried to redeem a receipt of another user", user.ID))
+	return Purchase{}, ErrReceiptAlreadyUsed
+}
+
+//createPurchase stores the purchase and credits the coins in one transaction
+func (user *User) createPurchase(platform string, verifiedPurchase VerifiedPurchase, coins int, receipt string) (Purchase, error) {
+	tx := GetDatabaseSession().Begin()
+
+	purchase := Purchase{
+		UserRefer:     user.ID,
+		Platform:      platform,
+		TransactionID: verifiedPurchase.TransactionID,
+		ProductID:     verifiedPurchase.ProductID,
+		State:         PurchaseStateCompleted,
+		Sandbox:       verifiedPurchase.Sandbox,
+		Coins:         coins,
+		Receipt:       receipt,
+	}
+
+	// the unique index on platform and transaction id stops parallel replays
+	if result := tx.Create(&purchase); result.Error != nil {
+		tx.Rollback()
+		if isDuplicateKeyError(result.Error) {
+			return Purchase{}, ErrReceiptAlreadyUsed
+		}
+		return Purchase{}, result.Error
+	}
+
+	idempotencyKey := fmt.Sprintf("%s:%s:%s", CoinReasonPurchase, platform, purchase.TransactionID)
+	coinTransaction, err := user.bookCoinTransaction(tx, coins, CoinReasonPurchase, idempotencyKey)
+	if err != nil {
+		tx.Rollback()
+		return Purchase{}, err
+	}
+
+	purchase.CoinTransactionRefer = coinTransaction.ID
+	if result := tx.Save(&purchase); result.Error != nil {
+		tx.Rollback()
+		return Purchase{}, result.Error
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return Purchase{}, result.Error
+	}
+
+	return purchase, nil
+}
+
+//isDuplicateKeyError checks if the database rejected a row because of a
+//unique index
+func isDuplicateKeyError(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == mysqlDuplicateEntry
+}
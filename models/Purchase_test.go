@@ -0,0 +1,133 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"timedrop/config"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestFakeReceiptVerifier(t *testing.T) {
+	var verifier FakeReceiptVerifier
+
+	verifiedPurchases, err := verifier.Verify("fake:1000001", "coins_100")
+	if err != nil {
+		t.Fatalf("valid fake receipt rejected: %v", err)
+	}
+	if len(verifiedPurchases) != 1 || verifiedPurchases[0].TransactionID != "1000001" || verifiedPurchases[0].ProductID != "coins_100" {
+		t.Errorf("unexpected purchases %+v", verifiedPurchases)
+	}
+
+	for _, receipt := range []string{"", "fake:", "1000001", "real:1000001"} {
+		if _, err := verifier.Verify(receipt, "coins_100"); err != ErrInvalidReceipt {
+			t.Errorf("receipt %q: got %v, want %v", receipt, err, ErrInvalidReceipt)
+		}
+	}
+}
+
+//newTestAppStore answers verifyReceipt requests with a receipt of the bundle,
+//sandboxStatus is the status of the production endpoint for sandbox receipts
+func newTestAppStore(bundleID string, sandboxStatus int) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		var response appStoreVerifyResponse
+		if req.URL.Path == "/production" {
+			response.Status = sandboxStatus
+		} else {
+			response.Environment = appStoreEnvironmentSandbox
+		}
+		response.Receipt.BundleID = bundleID
+		response.Receipt.InApp = append(response.Receipt.InApp, struct {
+			ProductID     string `json:"product_id"`
+			TransactionID string `json:"transaction_id"`
+		}{"coins_100", "1000001"})
+		json.NewEncoder(res).Encode(response)
+	}))
+}
+
+func TestAppStoreReceiptVerifier(t *testing.T) {
+	tests := []struct {
+		name          string
+		bundleID      string
+		sandboxStatus int
+		acceptSandbox bool
+		want          error
+		wantSandbox   bool
+	}{
+		{"production receipt", "com.faktorzwei.puzzle.timedrop", 0, false, nil, false},
+		{"other app", "com.example.other", 0, false, ErrInvalidReceipt, false},
+		{"no bundle id", "", 0, false, ErrInvalidReceipt, false},
+		{"sandbox receipt", "com.faktorzwei.puzzle.timedrop", appStoreStatusSandboxReceipt, false, ErrInvalidReceipt, false},
+		{"sandbox receipt accepted", "com.faktorzwei.puzzle.timedrop", appStoreStatusSandboxReceipt, true, nil, true},
+		{"sandbox receipt of other app", "com.example.other", appStoreStatusSandboxReceipt, true, ErrInvalidReceipt, false},
+	}
+
+	for _, test := range tests {
+		store := newTestAppStore(test.bundleID, test.sandboxStatus)
+		verifier := AppStoreReceiptVerifier{
+			VerifyURL:     store.URL + "/production",
+			SandboxURL:    store.URL + "/sandbox",
+			BundleID:      "com.faktorzwei.puzzle.timedrop",
+			AcceptSandbox: test.acceptSandbox,
+		}
+
+		verifiedPurchases, err := verifier.Verify("receipt", "coins_100")
+		store.Close()
+		if err != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+			continue
+		}
+		if err == nil && (len(verifiedPurchases) != 1 || verifiedPurchases[0].Sandbox != test.wantSandbox) {
+			t.Errorf("%s: got %+v, want one purchase with sandbox %v", test.name, verifiedPurchases, test.wantSandbox)
+		}
+	}
+}
+
+func TestIsDuplicateKeyError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"duplicate entry", &mysql.MySQLError{Number: 1062, Message: "Duplicate entry 'ios-1' for key 'idx_purchase_transaction'"}, true},
+		{"other mysql error", &mysql.MySQLError{Number: 1213, Message: "Deadlock found"}, false},
+		{"connection error", errors.New("driver: bad connection"), false},
+		{"no error", nil, false},
+	}
+
+	for _, test := range tests {
+		if got := isDuplicateKeyError(test.err); got != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, got, test.want)
+		}
+	}
+}
+
+func TestRedeemPurchaseRejectsBeforeStoring(t *testing.T) {
+	config.Cfg.PurchaseSettings.CoinPacks = map[string]int{"coins_100": 100}
+	ReceiptVerifiers[PurchasePlatformIOS] = FakeReceiptVerifier{}
+	defer delete(ReceiptVerifiers, PurchasePlatformIOS)
+
+	user := User{BaseModel: BaseModel{ID: 1}}
+
+	tests := []struct {
+		name      string
+		platform  string
+		productID string
+		receipt   string
+		want      error
+	}{
+		{"unknown product", PurchasePlatformIOS, "coins_1000000", "fake:1", ErrUnknownProduct},
+		{"unknown platform", "windows", "coins_100", "fake:1", ErrUnknownPlatform},
+		{"invalid receipt", PurchasePlatformIOS, "coins_100", "not-a-receipt", ErrInvalidReceipt},
+	}
+
+	for _, test := range tests {
+		if _, err := user.RedeemPurchase(test.platform, test.productID, test.receipt); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.name, err, test.want)
+		}
+	}
+}
@@ -418,3 +418,144 @@ func (pushNotification PushNotification) GiveLifeRequestPush(requester User) (er
 
 	return nil
 }
+
+//SendLevelPromotionPush
+func (pushNotification PushNotification) SendLevelPromotionPush(receiver User, levelName string) (err error) {
+
+	for _, pushToken := range receiver.GetFireBaseTokens() {
+		var data PushNotificationFCM
+		data.Message = fmt.Sprintf(helpers.TranslateStr("push_level_promotion", receiver.Language), levelName)
+		data.Title = helpers.TranslateStr("fcm_push_title", receiver.Language)
+
+		ids := []string{
+			string(pushToken.Token),
+		}
+
+		c := fcm.NewFcmClient(firebaseApiKey)
+		c.NewFcmRegIdsMsg(ids, data)
+
+		status, err := c.Send()
+
+		if err == nil {
+			status.PrintResults()
+		} else {
+			fmt.Println(err)
+		}
+	}
+
+	apnsClient, err := pushNotification.GetNewAPNSClient()
+	if err != nil {
+		return err
+	}
+
+	// Create payload
+	p := apns.NewPayload()
+	p.APS.Alert.Body = fmt.Sprintf(helpers.TranslateStr("push_level_promotion", receiver.Language), levelName)
+	p.APS.ContentAvailable = 1
+
+	for _, pushToken := range receiver.GetAPNSTokens() {
+		m := apns.NewNotification()
+		m.Payload = p
+		m.DeviceToken = pushToken.Token
+		m.Priority = apns.PriorityImmediate
+
+		err := apnsClient.Send(m)
+		fmt.Println(err)
+	}
+
+	return nil
+}
+
+//SendAchievementUnlockedPush
+func (pushNotification PushNotification) SendAchievementUnlockedPush(receiver User, achievementName string) (err error) {
+
+	for _, pushToken := range receiver.GetFireBaseTokens() {
+		var data PushNotificationFCM
+		data.Message = fmt.Sprintf(helpers.TranslateStr("push_achievement_unlocked", receiver.Language), achievementName)
+		data.Title = helpers.TranslateStr("fcm_push_title", receiver.Language)
+
+		ids := []string{
+			string(pushToken.Token),
+		}
+
+		c := fcm.NewFcmClient(firebaseApiKey)
+		c.NewFcmRegIdsMsg(ids, data)
+
+		status, err := c.Send()
+
+		if err == nil {
+			status.PrintResults()
+		} else {
+			fmt.Println(err)
+		}
+	}
+
+	apnsClient, err := pushNotification.GetNewAPNSClient()
+	if err != nil {
+		return err
+	}
+
+	// Create payload
+	p := apns.NewPayload()
+	p.APS.Alert.Body = fmt.Sprintf(helpers.TranslateStr("push_achievement_unlocked", receiver.Language), achievementName)
+	p.APS.ContentAvailable = 1
+
+	for _, pushToken := range receiver.GetAPNSTokens() {
+		m := apns.NewNotification()
+		m.Payload = p
+		m.DeviceToken = pushToken.Token
+		m.Priority = apns.PriorityImmediate
+
+		err := apnsClient.Send(m)
+		fmt.Println(err)
+	}
+
+	return nil
+}
+
+//SendUsernameChangedPush
+func (pushNotification PushNotification) SendUsernameChangedPush(receiver User, username string) (err error) {
+
+	for _, pushToken := range receiver.GetFireBaseTokens() {
+		var data PushNotificationFCM
+		data.Message = fmt.Sprintf(helpers.TranslateStr("push_username_changed", receiver.Language), username)
+		data.Title = helpers.TranslateStr("fcm_push_title", receiver.Language)
+
+		ids := []string{
+			string(pushToken.Token),
+		}
+
+		c := fcm.NewFcmClient(firebaseApiKey)
+		c.NewFcmRegIdsMsg(ids, data)
+
+		status, err := c.Send()
+
+		if err == nil {
+			status.PrintResults()
+		} else {
+			fmt.Println(err)
+		}
+	}
+
+	apnsClient, err := pushNotification.GetNewAPNSClient()
+	if err != nil {
+		return err
+	}
+
+	// Create payload
+	p := apns.NewPayload()
+	p.APS.Alert.Body = fmt.Sprintf(helpers.TranslateStr("push_username_changed", receiver.Language), username)
+	p.APS.ContentAvailable = 1
+
+	for _, pushToken := range receiver.GetAPNSTokens() {
+		m := apns.NewNotification()
+		m.Payload = p
+		m.DeviceToken = pushToken.Token
+		m.Priority = apns.PriorityImmediate
+
+		err := apnsClient.Send(m)
+		fmt.Println(err)
+	}
+
+	return nil
+}
@@ -0,0 +1,322 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"timedrop/config"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+var (
+	PurchasePlatformIOS     = "ios"
+	PurchasePlatformAndroid = "android"
+)
+
+var (
+	ErrInvalidReceipt     = errors.New("invalid_receipt")
+	ErrReceiptUnavailable = errors.New("receipt_verification_unavailable")
+)
+
+//VerifiedPurchase is a single store transaction found in a valid receipt
+type VerifiedPurchase struct {
+	TransactionID string
+	ProductID     string
+	// Sandbox purchases are test purchases nobody paid for
+	Sandbox bool
+}
+
+//ReceiptVerifier checks a receipt with the store it was issued by
+type ReceiptVerifier interface {
+	Verify(receipt, productID string) ([]VerifiedPurchase, error)
+}
+
+//ReceiptVerifiers holds the verifier used for each purchase platform
+var ReceiptVerifiers = map[string]ReceiptVerifier{}
+
+var receiptHTTPClient = &http.Client{Timeout: 15 * time.Second}
+
+//InitReceiptVerifiers sets up the verifiers from the purchase settings
+func InitReceiptVerifiers() {
+	settings := config.Cfg.PurchaseSettings
+	if settings.UseFakeVerifier {
+		ReceiptVerifiers[PurchasePlatformIOS] = FakeReceiptVerifier{}
+		ReceiptVerifiers[PurchasePlatformAndroid] = FakeReceiptVerifier{}
+		return
+	}
+
+	ReceiptVerifiers[PurchasePlatformIOS] = &AppStoreReceiptVerifier{
+		VerifyURL:     settings.AppStoreVerifyURL,
+		SandboxURL:    settings.AppStoreSandboxURL,
+		SharedSecret:  settings.AppStoreSharedSecret,
+		BundleID:      settings.AppStoreBundleID,
+		AcceptSandbox: settings.AcceptSandboxReceipts,
+	}
+	ReceiptVerifiers[PurchasePlatformAndroid] = &GooglePlayReceiptVerifier{
+		PackageName:        settings.GooglePlayPackageName,
+		ServiceAccountFile: settings.GooglePlayServiceAccountFile,
+		AcceptSandbox:      settings.AcceptSandboxReceipts,
+	}
+}
+
+//FakeReceiptVerifier accepts receipts in the form "fake:<transactionId>" for
+//local development and tests
+type FakeReceiptVerifier struct{}
+
+//Verify a fake receipt
+func (verifier FakeReceiptVerifier) Verify(receipt, productID string) ([]VerifiedPurchase, error) {
+	if !strings.HasPrefix(receipt, "fake:") || len(receipt) == len("fake:") {
+		return nil, ErrInvalidReceipt
+	}
+
+	return []VerifiedPurchase{{
+		TransactionID: strings.TrimPrefix(receipt, "fake:"),
+		ProductID:     productID,
+	}}, nil
+}
+
+//AppStoreReceiptVerifier uses Apples verifyReceipt endpoint
+type AppStoreReceiptVerifier struct {
+	VerifyURL    string
+	SandboxURL   string
+	SharedSecret string
+	// BundleID the receipt has to be issued for
+	BundleID string
+	// AcceptSandbox allows receipts of TestFlight and development builds
+	AcceptSandbox bool
+}
+
+type appStoreVerifyResponse struct {
+	Status      int    `json:"status"`
+	Environment string `json:"environment"`
+	Receipt     struct {
+		BundleID string `json:"bundle_id"`
+		InApp    []struct {
+			ProductID     string `json:"product_id"`
+			TransactionID string `json:"transaction_id"`
+		} `json:"in_app"`
+	} `json:"receipt"`
+}
+
+// receipts of TestFlight and development builds have to go to the sandbox
+const appStoreStatusSandboxReceipt = 21007
+
+const appStoreEnvironmentSandbox = "Sandbox"
+
+//Verify an App Store receipt
+func (verifier *AppStoreReceiptVerifier) Verify(receipt, productID string) ([]VerifiedPurchase, error) {
+	response, err := verifier.post(verifier.VerifyURL, receipt)
+	if err != nil {
+		return nil, err
+	}
+	sandbox := response.Status == appStoreStatusSandboxReceipt
+	if sandbox {
+		if !verifier.AcceptSandbox {
+			return nil, ErrInvalidReceipt
+		}
+		if response, err = verifier.post(verifier.SandboxURL, receipt); err != nil {
+			return nil, err
+		}
+	}
+	if response.Status != 0 {
+		return nil, ErrInvalidReceipt
+	}
+	// a valid receipt of another app could contain the same product ids
+	if response.Receipt.BundleID == "" || response.Receipt.BundleID != verifier.BundleID {
+		return nil, ErrInvalidReceipt
+	}
+	sandbox = sandbox || response.Environment == appStoreEnvironmentSandbox
+	if sandbox && !verifier.AcceptSandbox {
+		return nil, ErrInvalidReceipt
+	}
+
+	var purchases []VerifiedPurchase
+	for _, inApp := range response.Receipt.InApp {
+		if inApp.ProductID != productID {
+			continue
+		}
+		purchases = append(purchases, VerifiedPurchase{
+			TransactionID: inApp.TransactionID,
+			ProductID:     inApp.ProductID,
+			Sandbox:       sandbox,
+		})
+	}
+
+	if len(purchases) == 0 {
+		return nil, ErrInvalidReceipt
+	}
+	return purchases, nil
+}
+
+func (verifier *AppStoreReceiptVerifier) post(verifyURL, receipt string) (appStoreVerifyResponse, error) {
+	var response appStoreVerifyResponse
+
+	body, _ := json.Marshal(map[string]string{
+		"receipt-data": receipt,
+		"password":     verifier.SharedSecret,
+	})
+
+	res, err := receiptHTTPClient.Post(verifyURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return response, ErrReceiptUnavailable
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != 200 {
+		return response, ErrReceiptUnavailable
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return response, ErrReceiptUnavailable
+	}
+	return response, nil
+}
+
+//GooglePlayReceiptVerifier uses the Google Play Developer API, the receipt
+//is the purchase token
+type GooglePlayReceiptVerifier struct {
+	PackageName        string
+	ServiceAccountFile string
+	// AcceptSandbox allows test purchases of license testers
+	AcceptSandbox bool
+
+	mutex       sync.Mutex
+	accessToken string
+	expiresAt   time.Time
+}
+
+type googleServiceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+}
+
+type googleProductPurchase struct {
+	PurchaseState int    `json:"purchaseState"`
+	OrderID       string `json:"orderId"`
+	// PurchaseType is only set for test purchases and promo codes
+	PurchaseType *int `json:"purchaseType"`
+}
+
+// purchaseState 0 is purchased, 1 canceled and 2 pending
+const googlePurchaseStatePurchased = 0
+
+// purchaseType 0 is a test purchase of a license tester
+const googlePurchaseTypeTest = 0
+
+//Verify a Google Play purchase token
+func (verifier *GooglePlayReceiptVerifier) Verify(receipt, productID string) ([]VerifiedPurchase, error) {
+	accessToken, err := verifier.getAccessToken()
+	if err != nil {
+		return nil, ErrReceiptUnavailable
+	}
+
+	purchaseURL := fmt.Sprintf("https://androidpublisher.googleapis.com/androidpublisher/v3/applications/%s/purchases/products/%s/tokens/%s",
+		url.PathEscape(verifier.PackageName), url.PathEscape(productID), url.PathEscape(receipt))
+
+	req, _ := http.NewRequest("GET", purchaseURL, nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+
+	res, err := receiptHTTPClient.Do(req)
+	if err != nil {
+		return nil, ErrReceiptUnavailable
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == 400 || res.StatusCode == 404 {
+		return nil, ErrInvalidReceipt
+	}
+	if res.StatusCode != 200 {
+		return nil, ErrReceiptUnavailable
+	}
+
+	var purchase googleProductPurchase
+	if err := json.NewDecoder(res.Body).Decode(&purchase); err != nil {
+		return nil, ErrReceiptUnavailable
+	}
+	if purchase.PurchaseState != googlePurchaseStatePurchased || purchase.OrderID == "" {
+		return nil, ErrInvalidReceipt
+	}
+	sandbox := purchase.PurchaseType != nil && *purchase.PurchaseType == googlePurchaseTypeTest
+	if sandbox && !verifier.AcceptSandbox {
+		return nil, ErrInvalidReceipt
+	}
+
+	return []VerifiedPurchase{{
+		TransactionID: purchase.OrderID,
+		ProductID:     productID,
+		Sandbox:       sandbox,
+	}}, nil
+}
+
+//getAccessToken exchanges a self signed service account JWT for an OAuth
+//access token and caches it until shortly before it expires
+func (verifier *GooglePlayReceiptVerifier) getAccessToken() (string, error) {
+	verifier.mutex.Lock()
+	defer verifier.mutex.Unlock()
+
+	if verifier.accessToken != "" && time.Now().Before(verifier.expiresAt) {
+		return verifier.accessToken, nil
+	}
+
+	data, err := ioutil.ReadFile(verifier.ServiceAccountFile)
+	if err != nil {
+		return "", err
+	}
+
+	var account googleServiceAccount
+	if err := json.Unmarshal(data, &account); err != nil {
+		return "", err
+	}
+
+	privateKey, err := jwt.ParseRSAPrivateKeyFromPEM([]byte(account.PrivateKey))
+	if err != nil {
+		return "", err
+	}
+
+	now := time.Now()
+	assertion, err := jwt.NewWithClaims(jwt.SigningMethodRS256, jwt.MapClaims{
+		"iss":   account.ClientEmail,
+		"scope": "https://www.googleapis.com/auth/androidpublisher",
+		"aud":   account.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	}).SignedString(privateKey)
+	if err != nil {
+		return "", err
+	}
+
+	res, err := receiptHTTPClient.PostForm(account.TokenURI, url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	})
+	if err != nil {
+		return "", err
+	}
+	defer res.Body.Close()
+
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&token); err != nil {
+		return "", err
+	}
+	if token.AccessToken == "" {
+		return "", errors.New("no access token received")
+	}
+
+	verifier.accessToken = token.AccessToken
+	verifier.expiresAt = now.Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+
+	return verifier.accessToken, nil
+}
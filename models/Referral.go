@@ -0,0 +1,307 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"timedrop/config"
+	"timedrop/helpers"
+)
+
+var (
+	ReferralSourceCode           = "code"
+	ReferralSourceLink           = "link"
+	ReferralSourceInstallRequest = "install_request"
+
+	ReferralStatePending  = "pending"
+	ReferralStateRewarded = "rewarded"
+
+	CoinReasonReferral = "referral"
+)
+
+var (
+	ErrReferralNotFound   = errors.New("referral_not_found")
+	ErrReferralExpired    = errors.New("referral_expired")
+	ErrReferralLinkUsed   = errors.New("referral_link_used")
+	ErrReferralSelf       = errors.New("referral_self")
+	ErrReferralSameDevice = errors.New("referral_same_device")
+	ErrReferralNoDevice   = errors.New("referral_device_required")
+	ErrAlreadyReferred    = errors.New("referral_already_attributed")
+)
+
+const inviteCodeLength = 8
+
+//ReferralCode is the permanent invite code of a user
+type ReferralCode struct {
+	BaseModel
+
+	UserRefer uint   `json:"userId" gorm:";unique_index"`
+	Code      string `json:"code" gorm:";unique_index"`
+}
+
+//ReferralLink is a single use deep-link token to share an invite
+type ReferralLink struct {
+	BaseModel
+
+	UserRefer uint       `json:"userId" sql:"index"`
+	Token     string     `json:"token" gorm:";unique_index"`
+	ExpiresAt time.Time  `json:"expiresAt"`
+	UsedAt    *time.Time `json:"usedAt"`
+	URL       string     `json:"url" gorm:"-"`
+}
+
+//Referral tracks an invited user until both sides got rewarded
+type Referral struct {
+	BaseModel
+
+	ReferrerRefer uint       `json:"referrerId" sql:"index"`
+	InviteeRefer  uint       `json:"inviteeId" gorm:";unique_index"`
+	Source        string     `json:"source"`
+	DeviceID      string     `json:"-" sql:"index"`
+	State         string     `json:"state"`
+	RewardedAt    *time.Time `json:"rewardedAt"`
+
+	Invitee User `json:"invitee" gorm:"-"`
+}
+
+func init() {
+	OnGameCompleted(rewardReferrals)
+}
+
+//GetReferralCode returns the invite code of the user and creates it on first use
+func (user *User) GetReferralCode() (ReferralCode, error) {
+	db := GetDatabaseSession()
+
+	var referralCode ReferralCode
+	db.Where("user_refer = ?", user.ID).First(&referralCode)
+	if referralCode.ID != 0 {
+		return referralCode, nil
+	}
+
+	// retry on the rare collision with an existing code
+	var err error
+	for i := 0; i < 5; i++ {
+		referralCode = ReferralCode{
+			UserRefer: user.ID,
+			Code:      helpers.GenerateInviteCode(inviteCodeLength),
+		}
+		if err = db.Create(&referralCode).Error; err == nil {
+			return referralCode, nil
+		}
+	}
+
+	return ReferralCode{}, err
+}
+
+//CreateReferralLink creates a new deep-link token for the user
+func (user *User) CreateReferralLink() (ReferralLink, error) {
+	db := GetDatabaseSession()
+	settings := config.Cfg.ReferralSettings
+
+	referralLink := ReferralLink{
+		UserRefer: user.ID,
+		Token:     helpers.GenerateSecureToken(16),
+		ExpiresAt: time.Now().AddDate(0, 0, settings.LinkExpiryDays),
+	}
+	if err := db.Create(&referralLink).Error; err != nil {
+		return ReferralLink{}, err
+	}
+
+	referralLink.URL = settings.LinkBaseURL + referralLink.Token
+	return referralLink, nil
+}
+
+//FindByReferrer lists the users invited by the given user
+func (referral Referral) FindByReferrer(userID interface{}) (referrals []Referral, err error) {
+	db := GetDatabaseSession()
+	result := db.Where("referrer_refer = ?", userID).Order("id desc").Find(&referrals)
+	return referrals, result.Error
+}
+
+//findReferrer resolves an invite code, deep-link token or install request
+//guid to the inviting user, a deep-link is returned as well so it can be
+//consumed
+func findReferrer(code, token string, now time.Time) (uint, string, *ReferralLink, error) {
+	db := GetDatabaseSession()
+
+	if code != "" {
+		var referralCode ReferralCode
+		db.Where("code = ?", code).First(&referralCode)
+		if referralCode.ID == 0 {
+			return 0, "", nil, ErrReferralNotFound
+		}
+		return referralCode.UserRefer, ReferralSourceCode, nil, nil
+	}
+
+	if token == "" {
+		return 0, "", nil, ErrReferralNotFound
+	}
+
+	var referralLink ReferralLink
+	db.Where("token = ?", token).First(&referralLink)
+	if referralLink.ID != 0 {
+		if referralLink.UsedAt != nil {
+			return 0, "", nil, ErrReferralLinkUsed
+		}
+		if now.After(referralLink.ExpiresAt) {
+			return 0, "", nil, ErrReferralExpired
+		}
+		return referralLink.UserRefer, ReferralSourceLink, &referralLink, nil
+	}
+
+	// older clients share the guid of an install request instead
+	var requesterRefer uint
+	db.Raw("SELECT requester_refer FROM install_requests WHERE guid = ? AND created_at >= ?", token,
+		now.AddDate(0, 0, -config.Cfg.ReferralSettings.LinkExpiryDays)).Row().Scan(&requesterRefer)
+	if requesterRefer == 0 {
+		return 0, "", nil, ErrReferralNotFound
+	}
+	return requesterRefer, ReferralSourceInstallRequest, nil, nil
+}
+
+//AttributeReferral links a newly registered user to the user who invited them
+func (user *User) AttributeReferral(code, token, deviceID string) (Referral, error) {
+	tmpLog := userLogger.New("func", "AttributeReferral")
+	db := GetDatabaseSession()
+	now := time.Now()
+
+	window := time.Duration(config.Cfg.ReferralSettings.AttributionWindowHours) * time.Hour
+	if now.Sub(user.CreatedAt) > window {
+		return Referral{}, ErrReferralExpired
+	}
+
+	referrerID, source, referralLink, err := findReferrer(code, token, now)
+	if err != nil {
+		return Referral{}, err
+	}
+
+	var referrer User
+	if err := referrer.FindByID(referrerID); err != nil {
+		return Referral{}, ErrReferralNotFound
+	}
+
+	// without a device the self and same device checks can't work
+	if deviceID == "" {
+		tmpLog.Info(fmt.Sprintf("referral of user '%d' has no device", user.ID))
+		return Referral{}, ErrReferralNoDevice
+	}
+
+	if referrer.ID == user.ID || referrer.DeviceID == deviceID {
+		tmpLog.Info(fmt.Sprintf("user '%d' tried to refer themselves", referrer.ID))
+		return Referral{}, ErrReferralSelf
+	}
+
+	var count int
+	db.Model(&Referral{}).Where("device_id = ?", deviceID).Count(&count)
+	if count > 0 {
+		tmpLog.Info(fmt.Sprintf("device of user '%d' was already referred", user.ID))
+		return Referral{}, ErrReferralSameDevice
+	}
+
+	referral := Referral{
+		ReferrerRefer: referrer.ID,
+		InviteeRefer:  user.ID,
+		Source:        source,
+		DeviceID:      deviceID,
+		State:         ReferralStatePending,
+	}
+
+	tx := db.Begin()
+
+	// the unique index on the invitee makes sure a user is only referred once
+	if err := tx.Create(&referral).Error; err != nil {
+		tx.Rollback()
+		return Referral{}, ErrAlreadyReferred
+	}
+
+	// a deep-link is single use, only the first attribution consumes it
+	if referralLink != nil {
+		result := tx.Exec("UPDATE referral_links SET used_at = ? WHERE id = ? AND used_at IS NULL", now, referralLink.ID)
+		if result.Error != nil {
+			tx.Rollback()
+			return Referral{}, result.Error
+		}
+		if result.RowsAffected == 0 {
+			tx.Rollback()
+			return Referral{}, ErrReferralLinkUsed
+		}
+	}
+
+	if err := tx.Commit().Error; err != nil {
+		return Referral{}, err
+	}
+
+	return referral, nil
+}
+
+//rewardReferrals rewards both sides of a pending referral once the invitee
+//completed enough games
+func rewardReferrals(game Game) {
+	for _, playerID := range []uint{game.CreatorRefer, game.OpponentRefer} {
+		if playerID == 0 {
+			continue
+		}
+
+		var referral Referral
+		GetDatabaseSession().Where("invitee_refer = ? AND state = ?", playerID, ReferralStatePending).First(&referral)
+		if referral.ID == 0 {
+			continue
+		}
+
+		var invitee User
+		if err := invitee.FindByID(playerID); err != nil {
+			continue
+		}
+
+		if invitee.GamesPlayedCount < config.Cfg.ReferralSettings.RequiredGames {
+			continue
+		}
+
+		referral.reward(invitee)
+	}
+}
+
+//reward grants the configured coins and lives to the referrer and invitee
+func (referral Referral) reward(invitee User) {
+	tmpLog := userLogger.New("func", "Referral.reward")
+	db := GetDatabaseSession()
+	settings := config.Cfg.ReferralSettings
+
+	// only the call which flips the state hands out the rewards
+	result := db.Exec("UPDATE referrals SET state = ?, rewarded_at = NOW() WHERE id = ? AND state = ?",
+		ReferralStateRewarded, referral.ID, ReferralStatePending)
+	if result.Error != nil || result.RowsAffected == 0 {
+		return
+	}
+
+	var referrer User
+	if err := referrer.FindByID(referral.ReferrerRefer); err != nil {
+		tmpLog.Error(fmt.Sprintf("referrer of referral '%d' not found", referral.ID))
+		return
+	}
+
+	rewards := []struct {
+		user  User
+		side  string
+		coins int
+		lives int
+	}{
+		{referrer, "referrer", settings.ReferrerCoins, settings.ReferrerLives},
+		{invitee, "invitee", settings.InviteeCoins, settings.InviteeLives},
+	}
+
+	for _, reward := range rewards {
+		if reward.coins > 0 {
+			idempotencyKey := fmt.Sprintf("%s:%d:%s", CoinReasonReferral, referral.ID, reward.side)
+			if _, err := reward.user.GrantCoins(reward.coins, CoinReasonReferral, idempotencyKey); err != nil {
+				tmpLog.Error(fmt.Sprintf("couldn't grant referral coins to user '%d': %v", reward.user.ID, err))
+			}
+		}
+		if reward.lives > 0 {
+			if err := reward.user.AddLives(reward.lives); err != nil {
+				tmpLog.Error(fmt.Sprintf("couldn't grant referral lives to user '%d': %v", reward.user.ID, err))
+			}
+		}
+	}
+}
@@ -0,0 +1,261 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"timedrop/config"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+var (
+	ErrSaveNotFound        = errors.New("save_not_found")
+	ErrSaveKeyUnknown      = errors.New("save_key_unknown")
+	ErrSaveVersionConflict = errors.New("save_version_conflict")
+	ErrSaveTooLarge        = errors.New("save_too_large")
+)
+
+//SaveValidationError lists why data doesn't match the schema of its key
+type SaveValidationError struct {
+	Details []string
+}
+
+func (err SaveValidationError) Error() string {
+	return "save_invalid"
+}
+
+//Save is a versioned JSON document of client progress stored per user and key
+type Save struct {
+	BaseModel
+
+	UserRefer uint   `json:"-" gorm:"unique_index:idx_save_key"`
+	SaveKey   string `json:"key" gorm:"unique_index:idx_save_key"`
+	// Version is increased with every write, a write has to name the
+	// version it is based on
+	Version int    `json:"version"`
+	Data    string `json:"-" sql:"type:mediumtext"`
+}
+
+//saveSchemas holds the compiled schema of every allowed save key
+var saveSchemas = map[string]*gojsonschema.Schema{}
+
+//InitSaveSchemas compiles the schemas from the save settings
+func InitSaveSchemas() {
+	for key, schemaFile := range config.Cfg.SaveSettings.Schemas {
+		path, err := filepath.Abs(schemaFile)
+		if err != nil {
+			panic("Error opening save schema " + schemaFile + "\nerror: " + err.Error())
+		}
+
+		schema, err := gojsonschema.NewSchema(gojsonschema.NewReferenceLoader("file://" + filepath.ToSlash(path)))
+		if err != nil {
+			panic("Error loading save schema " + schemaFile + "\nerror: " + err.Error())
+		}
+		saveSchemas[key] = schema
+	}
+}
+
+//legacySaveData returns the users column a save key replaces, it is used as
+//initial data until the first save is written
+func (user *User) legacySaveData(key string) string {
+	switch key {
+	case "levelData":
+		return user.LevelData
+	case "achievementData":
+		return user.AchievementsData
+	case "treasureData":
+		return user.TreasureData
+	case "pergamentData":
+		return user.PergamentData
+	case "extraData":
+		return user.ExtraData
+	}
+	return ""
+}
+
+//ValidateSaveData checks the data against the size limit and the schema of the key
+func ValidateSaveData(key string, data []byte) error {
+	schema, ok := saveSchemas[key]
+	if !ok {
+		return ErrSaveKeyUnknown
+	}
+
+	maxSize := config.Cfg.SaveSettings.MaxSizeBytes
+	if maxSize > 0 && len(data) > maxSize {
+		return ErrSaveTooLarge
+	}
+
+	if !json.Valid(data) {
+		return SaveValidationError{Details: []string{"data is not valid JSON"}}
+	}
+
+	result, err := schema.Validate(gojsonschema.NewBytesLoader(data))
+	if err != nil {
+		return SaveValidationError{Details: []string{err.Error()}}
+	}
+	if !result.Valid() {
+		var details []string
+		for _, resultError := range result.Errors() {
+			details = append(details, resultError.String())
+		}
+		return SaveValidationError{Details: details}
+	}
+
+	return nil
+}
+
+//GetSave returns the save of the user for the key
+func (user *User) GetSave(key string) (Save, error) {
+	if _, ok := saveSchemas[key]; !ok {
+		return Save{}, ErrSaveKeyUnknown
+	}
+
+	db := GetDatabaseSession()
+
+	var save Save
+	db.Where("user_refer = ? AND save_key = ?", user.ID, key).First(&save)
+	if save.ID != 0 {
+		return save, nil
+	}
+
+	// fall back to the old users column, data the schema doesn't accept is
+	// served anyway so the client doesn't take the progress for lost
+	legacyData := strings.TrimSpace(user.legacySaveData(key))
+	if legacyData == "" {
+		return Save{}, ErrSaveNotFound
+	}
+	if err := ValidateSaveData(key, []byte(legacyData)); err != nil {
+		details := err.Error()
+		if validationError, ok := err.(SaveValidationError); ok {
+			details = strings.Join(validationError.Details, "; ")
+		}
+		userLogger.Info(fmt.Sprintf("legacy save '%s' of user '%d' doesn't match the schema: %s", key, user.ID, details))
+		if !json.Valid([]byte(legacyData)) {
+			quotedData, _ := json.Marshal(legacyData)
+			legacyData = string(quotedData)
+		}
+	}
+
+	return Save{
+		UserRefer: user.ID,
+		SaveKey:   key,
+		Data:      legacyData,
+	}, nil
+}
+
+//PutSave replaces the save of the key if it is still at the given version,
+//version 0 creates the save
+func (user *User) PutSave(key string, version int, data []byte) (Save, error) {
+	tmpLog := userLogger.New("func", "PutSave")
+
+	if err := ValidateSaveData(key, data); err != nil {
+		return Save{}, err
+	}
+
+	db := GetDatabaseSession()
+
+	if version == 0 {
+		save := Save{
+			UserRefer: user.ID,
+			SaveKey:   key,
+			Version:   1,
+			Data:      string(data),
+		}
+
+		// the unique index rejects a second device creating the same save
+		if result := db.Create(&save); result.Error != nil {
+			return Save{}, ErrSaveVersionConflict
+		}
+
+		user.recordSaveChange(key)
+		return save, nil
+	}
+
+	result := db.Exec("UPDATE saves SET data = ?, version = version + 1, updated_at = NOW() WHERE user_refer = ? AND save_key = ? AND version = ? AND deleted_at IS NULL",
+		string(data), user.ID, key, version)
+	if result.Error != nil {
+		return Save{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		tmpLog.Info(fmt.Sprintf("save '%s' of user '%d' is not at version %d", key, user.ID, version))
+		return Save{}, ErrSaveVersionConflict
+	}
+
+	var save Save
+	if result := db.Where("user_refer = ? AND save_key = ?", user.ID, key).First(&save); result.Error != nil {
+		return Save{}, result.Error
+	}
+
+	user.recordSaveChange(key)
+	return save, nil
+}
+
+//recordSaveChange bumps the sync revision of a save which is a sync field, so
+//a sync based on an older revision sees the write as conflict
+func (user *User) recordSaveChange(key string) {
+	if _, ok := syncFields[key]; !ok {
+		return
+	}
+	if err := user.recordFieldChanges([]string{key}); err != nil {
+		userLogger.Error(fmt.Sprintf("couldn't record sync change of save '%s' of user '%d': %v", key, user.ID, err))
+	}
+}
+
+//PatchSave applies a JSON merge patch (RFC 7386) to the save of the key if it
+//is still at the given version
+func (user *User) PatchSave(key string, version int, patch []byte) (Save, error) {
+	save, err := user.GetSave(key)
+	if err != nil && err != ErrSaveNotFound {
+		return Save{}, err
+	}
+	if save.Version != version {
+		return Save{}, ErrSaveVersionConflict
+	}
+
+	var current interface{} = map[string]interface{}{}
+	if save.Data != "" {
+		if err := json.Unmarshal([]byte(save.Data), &current); err != nil {
+			return Save{}, err
+		}
+	}
+
+	var patchValue interface{}
+	if err := json.Unmarshal(patch, &patchValue); err != nil {
+		return Save{}, SaveValidationError{Details: []string{"patch is not valid JSON"}}
+	}
+
+	data, err := json.Marshal(mergePatch(current, patchValue))
+	if err != nil {
+		return Save{}, err
+	}
+
+	return user.PutSave(key, version, data)
+}
+
+//mergePatch applies patch to target as described in RFC 7386, null values
+//remove members and objects are merged recursively
+func mergePatch(target, patch interface{}) interface{} {
+	patchObject, ok := patch.(map[string]interface{})
+	if !ok {
+		return patch
+	}
+
+	targetObject, ok := target.(map[string]interface{})
+	if !ok {
+		targetObject = map[string]interface{}{}
+	}
+
+	for name, value := range patchObject {
+		if value == nil {
+			delete(targetObject, name)
+			continue
+		}
+		targetObject[name] = mergePatch(targetObject[name], value)
+	}
+
+	return targetObject
+}
@@ -7,9 +7,11 @@ import (
 	"strconv"
 	"time"
 
+	"timedrop/config"
 	"timedrop/helpers"
 
 	log "github.com/inconshreveable/log15"
+	"github.com/jinzhu/gorm"
 	"gopkg.in/asaskevich/govalidator.v4"
 	"gopkg.in/gomail.v2"
 )
@@ -19,6 +21,18 @@ var UserLanguageEn = "en_US"
 
 var userLogger = log.New("models", "user")
 
+//ServerOwnedUserColumns are only changed by their own locked updates (coin
+//ledger, lives, sync) and have to be left out when saving a whole user
+var ServerOwnedUserColumns = []string{"coins", "lives", "lives_updated_at", "sync_revision"}
+
+//GameCountUserColumns are only counted up when a game ends, achievements rely
+//on them so a profile update must not write them
+var GameCountUserColumns = []string{"games_played_count", "games_won_count"}
+
+//ProgressUserColumns follow from the score of completed games, promotions pay
+//coins so a profile update must not write them either
+var ProgressUserColumns = []string{"score", "level", "level_refer", "top_level", "top_level_refer"}
+
 //User struct handels user
 type User struct {
 	BaseModel
@@ -27,7 +41,25 @@ type User struct {
 	Email    string `json:"email" valid:"email"`
 	Language string `json:"language"`
 
-	Guest bool `json:"guest"`
+	// UsernameSkeleton makes lookalike names collide, see UsernameSkeleton
+	UsernameSkeleton string `json:"-" sql:"index"`
+	// Discoverable users can be found by the search
+	Discoverable bool `json:"discoverable" sql:"default:true"`
+	// ShareActivity puts the events of the user into the feed of friends
+	ShareActivity bool `json:"shareActivity" sql:"default:true"`
+
+	// VerifiedEmail is the email which was confirmed with a code
+	VerifiedEmail string `json:"-"`
+	EmailHash     string `json:"-" sql:"index"`
+
+	Guest    bool   `json:"guest"`
+	DeviceID string `json:"-" sql:"index"`
+	// IsBot marks the system users which take unmatched games, it is only set
+	// by EnsureBots
+	IsBot bool `json:"-" sql:"index"`
+	// IsDeletedPlaceholder marks the user which replaces deleted users in
+	// games, it is only set by EnsureDeletedUser
+	IsDeletedPlaceholder bool `json:"-"`
 
 	FacebookID    string    `json:"facebookId"`
 	FbImageUrl    string    `json:"fbImageUrl"`
@@ -50,10 +82,25 @@ type User struct {
 	TopLevel      string `json:"topLevel"`
 	TopLevelRefer uint   `json:"topLevelRefer"`
 
+	// LevelProtectedUntil is the games played count until which the user
+	// can't be demoted after a promotion
+	LevelProtectedUntil int `json:"levelProtectedUntil"`
+
+	// AchievementsData is client state only, unlocked achievements are
+	// tracked by the server, see UserAchievement
 	AchievementsData string `json:"achievementData"`
 	TreasureData     string `json:"treasureData"`
 	PergamentData    string `json:"pergamentData"`
-	Coins            int    `json:"coins"`
+
+	// SyncRevision is increased whenever a synced field changes, see Sync
+	SyncRevision int `json:"syncRevision"`
+
+	// Coins is kept in sync by the coin ledger, see CoinTransaction
+	Coins int `json:"coins"`
+
+	Lives          int        `json:"lives"`
+	LivesUpdatedAt *time.Time `json:"-"`
+	NextLifeAt     *time.Time `json:"nextLifeAt,omitempty" gorm:"-"`
 
 	LoginCodes []LoginCode `json:"-" gorm:"many2many:user_logincodes;"`
 	PushTokens []PushToken `gorm:"ForeignKey:UserRefer" json:"-"`
@@ -138,29 +185,95 @@ func (user *User) Save() error {
 		user.Language = UserLanguageEn
 	}
 
-	var level Level
-	level.FindByScore(user.Score)
+	var previousLevel Level
+	if user.LevelRefer != 0 {
+		previousLevel.FindByID(user.LevelRefer)
+	}
+
+	var topLevel Level
+	if user.TopLevelRefer != 0 {
+		topLevel.FindByID(user.TopLevelRefer)
+	}
+
+	var scoreLevel Level
+	scoreLevel.FindByScore(user.Score)
+
+	// the level rules only need the full list once the level changes
+	var levels []Level
+	if scoreLevel.ID != previousLevel.ID {
+		var err error
+		if levels, err = scoreLevel.FindAll(); err != nil {
+			return err
+		}
+	}
+
+	level := resolveLevel(scoreLevel, previousLevel, topLevel, levels, user.GamesPlayedCount, user.LevelProtectedUntil, config.MaxLevelsBelowTop)
 	user.Level = level.Name
 	user.LevelRefer = level.ID
 
 	if user.TopLevel == "" {
 		user.TopLevel = user.Level
 		user.TopLevelRefer = user.LevelRefer
+	}
+
+	rewardCoins := 0
+	if topLevel.ID != 0 && level.Order > topLevel.Order {
+		rewardCoins = promotionCoins(levels, topLevel, level)
+		user.TopLevel = user.Level
+		user.TopLevelRefer = user.LevelRefer
+	}
+
+	isPromotion := previousLevel.ID != 0 && level.Order > previousLevel.Order
+	if isPromotion {
+		user.LevelProtectedUntil = user.GamesPlayedCount + level.ProtectionGames
+	}
+
+	user.UserUpdatedAt = time.Now()
+	user.UsernameSkeleton = UsernameSkeleton(user.Username)
+	user.SetContactHashes()
+
+	var result *gorm.DB
+	if user.ID == 0 {
+		// new users start with the server defaults, coins only come from the ledger
+		now := time.Now()
+		user.Coins = 0
+		user.SyncRevision = 0
+		user.Lives = config.Cfg.GameSettings.MaxLives
+		user.LivesUpdatedAt = &now
+		result = db.Save(&user)
 	} else {
-		var topLevel Level
-		topLevel.FindByID(user.TopLevelRefer)
-		if topLevel.ID != 0 {
-			if level.Order > topLevel.Order {
-				user.TopLevel = user.Level
-				user.TopLevelRefer = user.LevelRefer
-			}
+		// the synced fields are only written by a sync or a profile update
+		result = db.Omit(append(ServerOwnedUserColumns, SyncedUserColumns...)...).Save(&user)
+	}
+	if result.Error != nil {
+		return result.Error
+	}
+
+	if err := user.UpdateSearchIndex(); err != nil {
+		userLogger.Error(fmt.Sprintf("couldn't update search index of user '%d': %v", user.ID, err))
+	}
+
+	if rewardCoins > 0 {
+		idempotencyKey := fmt.Sprintf("%s:%d", CoinReasonLevelPromotion, level.ID)
+		if _, err := user.GrantCoins(rewardCoins, CoinReasonLevelPromotion, idempotencyKey); err != nil {
+			userLogger.Error(fmt.Sprintf("couldn't grant promotion coins to user '%d': %v", user.ID, err))
 		}
 	}
 
-	user.UserUpdatedAt = time.Now()
+	if previousLevel.ID != 0 && previousLevel.ID != level.ID {
+		emitLevelChange(LevelChange{
+			User:      *user,
+			FromLevel: previousLevel,
+			ToLevel:   level,
+		})
+	}
 
-	result := db.Save(&user)
-	return result.Error
+	if isPromotion {
+		var pushNotification PushNotification
+		go pushNotification.SendLevelPromotionPush(*user, level.Name)
+	}
+
+	return nil
 }
 
 //AppendLoginCode to user obj
@@ -319,6 +432,28 @@ func (user *User) ValidateUserLoginCode(code string, userId uint) bool {
 	return false
 }
 
+//loginCodeQuery matches a login code of the user which is not used and not
+//older than maxAge
+const loginCodeQuery = `code = ? AND deleted_at IS NULL AND created_at > ?
+	AND id IN (SELECT login_code_id FROM user_logincodes WHERE user_id = ?)`
+
+//HasLoginCode checks a login code without using it up
+func (user *User) HasLoginCode(code string, maxAge time.Duration) bool {
+	db := GetDatabaseSession()
+	var count int
+	db.Model(&LoginCode{}).Where(loginCodeQuery, code, time.Now().Add(-maxAge), user.ID).Count(&count)
+	return count == 1
+}
+
+//ConsumeLoginCode validates a login code and deletes it in the same
+//statement, so it can only be used once
+func (user *User) ConsumeLoginCode(code string, maxAge time.Duration) bool {
+	db := GetDatabaseSession()
+	result := db.Exec("UPDATE login_codes SET deleted_at = ? WHERE "+loginCodeQuery,
+		time.Now(), code, time.Now().Add(-maxAge), user.ID)
+	return result.Error == nil && result.RowsAffected == 1
+}
+
 //ValidateEmailCode validates the email code
 func (user *User) ValidateEmailCode(code string) (email string, err error) {
 	tmpLog := userLogger.New("func", "ValidateEmailCode")
@@ -407,7 +542,7 @@ func GetGuestUsername(guestId int) string {
 	}
 
 	db.Where(userQuery).Find(&user)
-	if user.ID != 0 {
+	if user.ID != 0 || user.IsUsernameTaken(userName) {
 		return GetGuestUsername(guestId + 1)
 	}
 
@@ -432,16 +567,24 @@ func (user *User) FindAllByFacebookIDs(friends []uint, userId uint) []int {
 //AddUserFriends
 func (user *User) AddUserFriends(users []int, userId uint) {
 	db := GetDatabaseSession()
+	var block Block
 	for _, user := range users {
+		if block.IsBlockedBetween(userId, user) {
+			continue
+		}
 		db.Exec("DELETE FROM friend_requests WHERE (requester_refer = ? OR receiver_refer = ?) AND (requester_refer = ? OR receiver_refer = ?)",
 			userId, userId, user, user)
-		db.Exec("INSERT IGNORE INTO friends (requester_refer, receiver_refer, created_at) VALUES (?, ?, NOW())", userId, user)
+		result := db.Exec("INSERT IGNORE INTO friends (requester_refer, receiver_refer, created_at) VALUES (?, ?, NOW())", userId, user)
+		if result.Error == nil && result.RowsAffected > 0 {
+			emitFriendAdded(Friend{RequesterRefer: userId, ReceiverRefer: uint(user)})
+		}
 	}
 }
 
 func (user *User) DeleteFacebookData() {
 	db := GetDatabaseSession()
 	db.Exec("UPDATE users SET facebook_id = null, fb_image_url = null, fb_name = null, is_verified = null WHERE id = ? LIMIT 1", user.ID)
+	db.Exec("DELETE FROM user_identities WHERE user_refer = ? AND provider = ?", user.ID, IdentityProviderFacebook)
 }
 
 func (user *User) DeleteEmailData() {
@@ -0,0 +1,348 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"timedrop/config"
+
+	"github.com/jinzhu/gorm"
+	"gopkg.in/asaskevich/govalidator.v4"
+)
+
+// relevance of a term match, fuzzy matches lose some per edit
+const (
+	searchScoreExact  = 100
+	searchScorePrefix = 60
+	searchScoreFuzzy  = 40
+
+	searchBoostFriendOfFriend = 50
+	searchBoostFriend         = 25
+	// every mutual friend counts, up to this many
+	searchMaxMutualBoost = 10
+)
+
+// longer terms don't make a difference for matching
+const searchTermMaxLength = 64
+
+//UserSearchTerm is a normalized word of the username or Facebook name of a
+//user, see UsernameSkeleton
+type UserSearchTerm struct {
+	BaseModel
+
+	UserRefer uint   `sql:"index"`
+	Term      string `sql:"size:64;index"`
+}
+
+//UserSearchResult is a user found by a search with its relevance
+type UserSearchResult struct {
+	User          User
+	Relevance     int
+	IsFriend      bool
+	MutualFriends int
+}
+
+//searchTerms returns the terms a user can be found by, the whole names and
+//every word of them
+func (user *User) searchTerms() []string {
+	var terms []string
+	seen := make(map[string]bool)
+	add := func(term string) {
+		if utf8.RuneCountInString(term) > searchTermMaxLength {
+			term = string([]rune(term)[:searchTermMaxLength])
+		}
+		if term != "" && !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	for _, name := range []string{user.Username, user.FbName} {
+		add(UsernameSkeleton(name))
+		for _, word := range strings.FieldsFunc(name, func(char rune) bool {
+			return strings.ContainsRune(usernameSeparators, char)
+		}) {
+			add(UsernameSkeleton(word))
+		}
+	}
+
+	sort.Strings(terms)
+	return terms
+}
+
+//UpdateSearchIndex writes the search terms of the user if the names changed
+func (user *User) UpdateSearchIndex() error {
+	db := GetDatabaseSession()
+
+	var terms []string
+	if !user.IsBot {
+		terms = user.searchTerms()
+	}
+
+	var existing []string
+	db.Model(&UserSearchTerm{}).Where("user_refer = ?", user.ID).Order("term asc").Pluck("term", &existing)
+	if strings.Join(existing, "\n") == strings.Join(terms, "\n") {
+		return nil
+	}
+
+	tx := db.Begin()
+	if result := tx.Unscoped().Where("user_refer = ?", user.ID).Delete(UserSearchTerm{}); result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	for _, term := range terms {
+		if result := tx.Create(&UserSearchTerm{UserRefer: user.ID, Term: term}); result.Error != nil {
+			tx.Rollback()
+			return result.Error
+		}
+	}
+	return tx.Commit().Error
+}
+
+//BackfillSearchIndex indexes the users which have no search terms yet
+func BackfillSearchIndex() {
+	db := GetDatabaseSession()
+
+	var lastID uint
+	for {
+		var users []User
+		db.Where("id > ? AND is_bot = 0 AND id NOT IN (SELECT user_refer FROM user_search_terms)", lastID).
+			Order("id asc").Limit(500).Find(&users)
+		if len(users) == 0 {
+			return
+		}
+
+		for _, user := range users {
+			if err := user.UpdateSearchIndex(); err != nil {
+				userLogger.Error(fmt.Sprintf("couldn't index user '%d': %v", user.ID, err))
+			}
+			lastID = user.ID
+		}
+	}
+}
+
+//levenshtein counts the edits needed to turn one string into the other
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	previous := make([]int, len(rb)+1)
+	current := make([]int, len(rb)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		current[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			current[j] = minInt(previous[j]+1, minInt(current[j-1]+1, previous[j-1]+cost))
+		}
+		previous, current = current, previous
+	}
+	return previous[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+//termRelevance rates how well an indexed term matches the query, fuzzy
+//matches are compared against the start of the term as well so typos in a
+//prefix are found too
+func termRelevance(query, term string) int {
+	if term == query {
+		return searchScoreExact
+	}
+	if strings.HasPrefix(term, query) {
+		return searchScorePrefix
+	}
+
+	maxDistance := 1
+	if utf8.RuneCountInString(query) > 5 {
+		maxDistance = 2
+	}
+
+	distance := levenshtein(query, term)
+	if termRunes := []rune(term); len(termRunes) > len([]rune(query)) {
+		distance = minInt(distance, levenshtein(query, string(termRunes[:len([]rune(query))])))
+	}
+	if distance > maxDistance {
+		return 0
+	}
+	return searchScoreFuzzy - 10*distance
+}
+
+//likeEscaper escapes the wildcards of a LIKE pattern
+var likeEscaper = strings.NewReplacer("\\", "\\\\", "%", "\\%", "_", "\\_")
+
+//findSearchTerms loads up to limit candidate terms for the skeleton. Exact and
+//prefix matches of the whole skeleton come first so they can't be crowded out,
+//the rest is filled with terms sharing the first two characters, the ones
+//closest in length first.
+func findSearchTerms(skeleton string, limit int) ([]UserSearchTerm, error) {
+	db := GetDatabaseSession()
+	escaped := likeEscaper.Replace(skeleton)
+
+	// the first two characters have to match, that keeps the index usable
+	fuzzyPrefix := escaped
+	if runes := []rune(skeleton); len(runes) > 2 {
+		fuzzyPrefix = likeEscaper.Replace(string(runes[:2]))
+	}
+
+	queries := []*gorm.DB{
+		db.Where("term = ?", skeleton).Order("id asc"),
+		db.Where("term LIKE ? AND term != ?", escaped+"%", skeleton).Order("CHAR_LENGTH(term) asc, term asc"),
+		db.Where("term LIKE ? AND term NOT LIKE ?", fuzzyPrefix+"%", escaped+"%").
+			Order(fmt.Sprintf("ABS(CHAR_LENGTH(term) - %d) asc, term asc", utf8.RuneCountInString(skeleton))),
+	}
+
+	var terms []UserSearchTerm
+	for _, query := range queries {
+		if len(terms) >= limit {
+			break
+		}
+
+		var stageTerms []UserSearchTerm
+		if result := query.Limit(limit - len(terms)).Find(&stageTerms); result.Error != nil {
+			return nil, result.Error
+		}
+		terms = append(terms, stageTerms...)
+	}
+	return terms, nil
+}
+
+//SearchUsers finds users by name, or by email if the query is an exact
+//address. Friends of friends rank first, users who turned off discoverable
+//and blocked users are never found.
+func (user *User) SearchUsers(query string, page int) ([]UserSearchResult, error) {
+	db := GetDatabaseSession()
+	settings := config.Cfg.SearchSettings
+
+	relevance := make(map[uint]int)
+
+	if govalidator.IsEmail(query) {
+		var userByEmail User
+		db.Where("email = ?", query).First(&userByEmail)
+		if userByEmail.ID != 0 {
+			relevance[userByEmail.ID] = searchScoreExact
+		}
+	} else {
+		skeleton := UsernameSkeleton(query)
+		if skeleton == "" {
+			return []UserSearchResult{}, nil
+		}
+
+		terms, err := findSearchTerms(skeleton, settings.MaxCandidates)
+		if err != nil {
+			return nil, err
+		}
+		for _, term := range terms {
+			if score := termRelevance(skeleton, term.Term); score > relevance[term.UserRefer] {
+				relevance[term.UserRefer] = score
+			}
+		}
+	}
+	delete(relevance, user.ID)
+
+	if len(relevance) == 0 {
+		return []UserSearchResult{}, nil
+	}
+
+	candidateIDs := make([]uint, 0, len(relevance))
+	for candidateID := range relevance {
+		candidateIDs = append(candidateIDs, candidateID)
+	}
+
+	var users []User
+	blockedQuery := "id NOT IN (SELECT blocked_refer FROM blocks WHERE blocker_refer = ?) AND id NOT IN (SELECT blocker_refer FROM blocks WHERE blocked_refer = ?)"
+	result := db.Where("id IN (?) AND is_bot = 0 AND discoverable = 1", candidateIDs).
+		Where(blockedQuery, user.ID, user.ID).Find(&users)
+	if result.Error != nil {
+		return nil, result.Error
+	}
+
+	friendIDs, mutualFriends := user.friendRelations(candidateIDs)
+
+	results := []UserSearchResult{}
+	for _, foundUser := range users {
+		foundUser.Email = ""
+		searchResult := UserSearchResult{
+			User:          foundUser,
+			Relevance:     relevance[foundUser.ID],
+			IsFriend:      friendIDs[foundUser.ID],
+			MutualFriends: mutualFriends[foundUser.ID],
+		}
+		if searchResult.MutualFriends > 0 {
+			searchResult.Relevance += searchBoostFriendOfFriend + minInt(searchResult.MutualFriends, searchMaxMutualBoost)
+		}
+		if searchResult.IsFriend {
+			searchResult.Relevance += searchBoostFriend
+		}
+		results = append(results, searchResult)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Relevance != results[j].Relevance {
+			return results[i].Relevance > results[j].Relevance
+		}
+		if results[i].User.Score != results[j].User.Score {
+			return results[i].User.Score > results[j].User.Score
+		}
+		return results[i].User.ID < results[j].User.ID
+	})
+
+	start := page * settings.PageSize
+	if start >= len(results) {
+		return []UserSearchResult{}, nil
+	}
+	end := start + settings.PageSize
+	if end > len(results) {
+		end = len(results)
+	}
+	return results[start:end], nil
+}
+
+//friendRelations returns which of the candidates are friends of the user and
+//how many friends each candidate shares with the user
+func (user *User) friendRelations(candidateIDs []uint) (map[uint]bool, map[uint]int) {
+	db := GetDatabaseSession()
+
+	friendIDs := make(map[uint]bool)
+	mutualFriends := make(map[uint]int)
+
+	var friend Friend
+	friends, _ := friend.FindByUserID(user.ID)
+	var myFriendIDs []uint
+	for _, friend := range friends {
+		friendID := friend.RequesterRefer
+		if friendID == user.ID {
+			friendID = friend.ReceiverRefer
+		}
+		friendIDs[friendID] = true
+		myFriendIDs = append(myFriendIDs, friendID)
+	}
+	if len(myFriendIDs) == 0 {
+		return friendIDs, mutualFriends
+	}
+
+	var candidateFriends []Friend
+	db.Where("(requester_refer IN (?) AND receiver_refer IN (?)) OR (receiver_refer IN (?) AND requester_refer IN (?))",
+		candidateIDs, myFriendIDs, candidateIDs, myFriendIDs).Find(&candidateFriends)
+	for _, candidateFriend := range candidateFriends {
+		if friendIDs[candidateFriend.ReceiverRefer] {
+			mutualFriends[candidateFriend.RequesterRefer]++
+		}
+		if friendIDs[candidateFriend.RequesterRefer] {
+			mutualFriends[candidateFriend.ReceiverRefer]++
+		}
+	}
+
+	return friendIDs, mutualFriends
+}
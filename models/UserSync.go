@@ -0,0 +1,436 @@
+package models
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"github.com/jinzhu/gorm"
+)
+
+var (
+	SyncRuleMax        = "max"
+	SyncRuleUnion      = "union"
+	SyncRuleServerWins = "server"
+	SyncRuleClientWins = "client"
+
+	SyncResolutionMerged = "merged"
+	SyncResolutionServer = "server"
+	SyncResolutionClient = "client"
+)
+
+var (
+	ErrSyncFieldUnknown    = errors.New("sync_field_unknown")
+	ErrSyncFieldInvalid    = errors.New("sync_field_invalid")
+	ErrSyncRevisionInvalid = errors.New("sync_revision_invalid")
+)
+
+//syncField describes how a user field is stored and merged on conflicts.
+//Fields without a column are stored in the save of the same key.
+type syncField struct {
+	Column  string
+	Rule    string
+	Numeric bool
+}
+
+//syncFields are the user fields clients can change through a sync
+var syncFields = map[string]syncField{
+	"currentLevel":    {Column: "current_level", Rule: SyncRuleMax, Numeric: true},
+	"avatar":          {Column: "avatar", Rule: SyncRuleClientWins, Numeric: true},
+	"levelData":       {Rule: SyncRuleServerWins},
+	"achievementData": {Rule: SyncRuleUnion},
+	"treasureData":    {Rule: SyncRuleServerWins},
+	"pergamentData":   {Rule: SyncRuleUnion},
+	"extraData":       {Rule: SyncRuleServerWins},
+}
+
+//SyncedUserColumns are the user columns written by a sync, a whole user save
+//must leave them out to not overwrite a concurrent sync
+var SyncedUserColumns = []string{"current_level", "avatar"}
+
+//UserFieldRevision is the sync revision in which a user field last changed
+type UserFieldRevision struct {
+	BaseModel
+
+	UserRefer uint   `gorm:"unique_index:idx_user_field_revision"`
+	Field     string `gorm:"unique_index:idx_user_field_revision"`
+	Revision  int
+}
+
+//SyncConflict is a field which changed on another device since the base
+//revision of the client
+type SyncConflict struct {
+	Field       string      `json:"field"`
+	ServerValue interface{} `json:"serverValue"`
+	ClientValue interface{} `json:"clientValue"`
+	Resolution  string      `json:"resolution"`
+	Value       interface{} `json:"value"`
+}
+
+//SyncResult is the merged state after a sync
+type SyncResult struct {
+	Revision  int            `json:"revision"`
+	User      User           `json:"user"`
+	Conflicts []SyncConflict `json:"conflicts"`
+	// Saves holds the data of the synced save fields
+	Saves map[string]string `json:"saves"`
+}
+
+//syncValue returns the current value of a sync field stored on the user
+func (user *User) syncValue(field string) interface{} {
+	switch field {
+	case "currentLevel":
+		return user.CurrentLevel
+	case "avatar":
+		return user.Avatar
+	}
+	return nil
+}
+
+//lockSave reads the save of a sync field for update, a missing save starts
+//with the legacy users column
+func (user *User) lockSave(tx *gorm.DB, key string) (Save, error) {
+	var save Save
+	result := tx.Set("gorm:query_option", "FOR UPDATE").Where("user_refer = ? AND save_key = ?", user.ID, key).First(&save)
+	if result.Error != nil && result.Error != gorm.ErrRecordNotFound {
+		return Save{}, result.Error
+	}
+	if save.ID == 0 {
+		save = Save{
+			UserRefer: user.ID,
+			SaveKey:   key,
+			Data:      user.legacySaveData(key),
+		}
+	}
+	return save, nil
+}
+
+//writeSave stores the data of a sync field in its save and counts up the
+//version, so /saves clients see the change as conflict
+func writeSave(tx *gorm.DB, save Save, data string) error {
+	if err := ValidateSaveData(save.SaveKey, []byte(data)); err != nil {
+		return err
+	}
+
+	if save.ID == 0 {
+		save.Version = 1
+		save.Data = data
+		return tx.Create(&save).Error
+	}
+	return tx.Exec("UPDATE saves SET data = ?, version = version + 1, updated_at = NOW() WHERE id = ?", data, save.ID).Error
+}
+
+//decodeSyncValue decodes a client value into the type of the field
+func decodeSyncValue(field syncField, raw json.RawMessage) (interface{}, error) {
+	if field.Numeric {
+		var value int
+		if err := json.Unmarshal(raw, &value); err != nil {
+			return nil, ErrSyncFieldInvalid
+		}
+		return value, nil
+	}
+
+	var value string
+	if err := json.Unmarshal(raw, &value); err != nil {
+		return nil, ErrSyncFieldInvalid
+	}
+	return value, nil
+}
+
+//mergeSyncValue resolves a conflicting change by the rule of the field
+func mergeSyncValue(field syncField, serverValue, clientValue interface{}) (interface{}, string) {
+	switch field.Rule {
+	case SyncRuleMax:
+		if clientValue.(int) > serverValue.(int) {
+			return clientValue, SyncResolutionMerged
+		}
+		return serverValue, SyncResolutionMerged
+	case SyncRuleUnion:
+		if merged, ok := unionJSON(serverValue.(string), clientValue.(string)); ok {
+			return merged, SyncResolutionMerged
+		}
+	case SyncRuleClientWins:
+		return clientValue, SyncResolutionClient
+	}
+	return serverValue, SyncResolutionServer
+}
+
+//unionJSON merges two JSON documents: arrays are united, objects are merged
+//key by key. It fails if the documents can't be merged.
+func unionJSON(serverData, clientData string) (string, bool) {
+	if serverData == "" {
+		return clientData, true
+	}
+	if clientData == "" {
+		return serverData, true
+	}
+
+	var serverValue, clientValue interface{}
+	if json.Unmarshal([]byte(serverData), &serverValue) != nil || json.Unmarshal([]byte(clientData), &clientValue) != nil {
+		return "", false
+	}
+
+	merged, ok := unionValues(serverValue, clientValue)
+	if !ok {
+		return "", false
+	}
+
+	data, err := json.Marshal(merged)
+	if err != nil {
+		return "", false
+	}
+	return string(data), true
+}
+
+func unionValues(serverValue, clientValue interface{}) (interface{}, bool) {
+	switch server := serverValue.(type) {
+	case []interface{}:
+		client, ok := clientValue.([]interface{})
+		if !ok {
+			return nil, false
+		}
+		merged := append([]interface{}{}, server...)
+		for _, clientElement := range client {
+			found := false
+			for _, serverElement := range server {
+				if reflect.DeepEqual(serverElement, clientElement) {
+					found = true
+					break
+				}
+			}
+			if !found {
+				merged = append(merged, clientElement)
+			}
+		}
+		return merged, true
+	case map[string]interface{}:
+		client, ok := clientValue.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		merged := map[string]interface{}{}
+		for key, value := range server {
+			merged[key] = value
+		}
+		for key, value := range client {
+			serverElement, exists := merged[key]
+			if !exists {
+				merged[key] = value
+				continue
+			}
+			if element, ok := unionValues(serverElement, value); ok {
+				merged[key] = element
+			}
+		}
+		return merged, true
+	case bool:
+		// an unlocked flag stays unlocked
+		client, ok := clientValue.(bool)
+		return server || client, ok
+	case float64:
+		client, ok := clientValue.(float64)
+		if client > server {
+			return client, ok
+		}
+		return server, ok
+	}
+
+	return serverValue, reflect.DeepEqual(serverValue, clientValue)
+}
+
+//Sync applies the field changes a client made on top of baseRevision. Fields
+//which changed on the server since then are merged by their rule and
+//reported as conflicts.
+func (user *User) Sync(baseRevision int, changes map[string]json.RawMessage) (SyncResult, error) {
+	tmpLog := userLogger.New("func", "Sync")
+
+	// validate all changes before touching anything
+	clientValues := make(map[string]interface{})
+	for name, raw := range changes {
+		field, ok := syncFields[name]
+		if !ok {
+			return SyncResult{}, ErrSyncFieldUnknown
+		}
+		value, err := decodeSyncValue(field, raw)
+		if err != nil {
+			return SyncResult{}, err
+		}
+		clientValues[name] = value
+	}
+
+	tx := GetDatabaseSession().Begin()
+
+	var lockedUser User
+	if result := tx.Set("gorm:query_option", "FOR UPDATE").First(&lockedUser, user.ID); result.Error != nil {
+		tx.Rollback()
+		return SyncResult{}, result.Error
+	}
+
+	if baseRevision < 0 || baseRevision > lockedUser.SyncRevision {
+		tx.Rollback()
+		return SyncResult{}, ErrSyncRevisionInvalid
+	}
+
+	fieldRevisions, err := findFieldRevisions(tx, user.ID)
+	if err != nil {
+		tx.Rollback()
+		return SyncResult{}, err
+	}
+
+	names := make([]string, 0, len(clientValues))
+	for name := range clientValues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	conflicts := []SyncConflict{}
+	columns := make(map[string]interface{})
+	saves := make(map[string]Save)
+	saveValues := make(map[string]string)
+	var changedFields []string
+	for _, name := range names {
+		field := syncFields[name]
+		serverValue := lockedUser.syncValue(name)
+		if field.Column == "" {
+			save, err := lockedUser.lockSave(tx, name)
+			if err != nil {
+				tx.Rollback()
+				return SyncResult{}, err
+			}
+			saves[name] = save
+			serverValue = save.Data
+		}
+		value := clientValues[name]
+
+		if fieldRevisions[name] > baseRevision && serverValue != value {
+			var resolution string
+			value, resolution = mergeSyncValue(field, serverValue, clientValues[name])
+			conflicts = append(conflicts, SyncConflict{
+				Field:       name,
+				ServerValue: serverValue,
+				ClientValue: clientValues[name],
+				Resolution:  resolution,
+				Value:       value,
+			})
+		}
+
+		if field.Column == "" {
+			saveValues[name] = value.(string)
+		}
+
+		if value != serverValue {
+			if field.Column == "" {
+				if err := writeSave(tx, saves[name], value.(string)); err != nil {
+					tx.Rollback()
+					return SyncResult{}, err
+				}
+			} else {
+				columns[field.Column] = value
+			}
+			changedFields = append(changedFields, name)
+		}
+	}
+
+	revision := lockedUser.SyncRevision
+	if len(changedFields) > 0 {
+		revision++
+		columns["sync_revision"] = revision
+		if result := tx.Model(&User{}).Where("id = ?", user.ID).UpdateColumns(columns); result.Error != nil {
+			tx.Rollback()
+			return SyncResult{}, result.Error
+		}
+		if err := saveFieldRevisions(tx, user.ID, changedFields, revision); err != nil {
+			tx.Rollback()
+			return SyncResult{}, err
+		}
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return SyncResult{}, result.Error
+	}
+
+	if len(conflicts) > 0 {
+		tmpLog.Info(fmt.Sprintf("sync of user '%d' on revision %d had %d conflicts", user.ID, baseRevision, len(conflicts)))
+	}
+
+	if err := user.FindByID(user.ID); err != nil {
+		return SyncResult{}, err
+	}
+
+	return SyncResult{
+		Revision:  user.SyncRevision,
+		User:      *user,
+		Conflicts: conflicts,
+		Saves:     saveValues,
+	}, nil
+}
+
+//RecordSyncChanges bumps the sync revision for the fields which differ from
+//previous, used by endpoints which change the user without a sync
+func (user *User) RecordSyncChanges(previous User) error {
+	var changedFields []string
+	for name, field := range syncFields {
+		if field.Column != "" && user.syncValue(name) != previous.syncValue(name) {
+			changedFields = append(changedFields, name)
+		}
+	}
+	return user.recordFieldChanges(changedFields)
+}
+
+//recordFieldChanges bumps the sync revision for the changed fields
+func (user *User) recordFieldChanges(changedFields []string) error {
+	if len(changedFields) == 0 {
+		return nil
+	}
+
+	tx := GetDatabaseSession().Begin()
+
+	var lockedUser User
+	if result := tx.Set("gorm:query_option", "FOR UPDATE").First(&lockedUser, user.ID); result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+
+	revision := lockedUser.SyncRevision + 1
+	if result := tx.Exec("UPDATE users SET sync_revision = ? WHERE id = ?", revision, user.ID); result.Error != nil {
+		tx.Rollback()
+		return result.Error
+	}
+	if err := saveFieldRevisions(tx, user.ID, changedFields, revision); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if result := tx.Commit(); result.Error != nil {
+		return result.Error
+	}
+
+	user.SyncRevision = revision
+	return nil
+}
+
+func findFieldRevisions(tx *gorm.DB, userID uint) (map[string]int, error) {
+	var userFieldRevisions []UserFieldRevision
+	if result := tx.Where("user_refer = ?", userID).Find(&userFieldRevisions); result.Error != nil {
+		return nil, result.Error
+	}
+
+	fieldRevisions := make(map[string]int)
+	for _, userFieldRevision := range userFieldRevisions {
+		fieldRevisions[userFieldRevision.Field] = userFieldRevision.Revision
+	}
+	return fieldRevisions, nil
+}
+
+func saveFieldRevisions(tx *gorm.DB, userID uint, fields []string, revision int) error {
+	for _, field := range fields {
+		result := tx.Exec("INSERT INTO user_field_revisions (user_refer, field, revision, created_at, updated_at) VALUES (?, ?, ?, NOW(), NOW()) "+
+			"ON DUPLICATE KEY UPDATE revision = VALUES(revision), updated_at = NOW()", userID, field, revision)
+		if result.Error != nil {
+			return result.Error
+		}
+	}
+	return nil
+}
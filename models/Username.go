@@ -0,0 +1,249 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"timedrop/config"
+)
+
+var (
+	// the misspelled code is kept, clients already translate it
+	ErrUsernameLength     = errors.New("username_lenght")
+	ErrUsernameCharset    = errors.New("username_charset")
+	ErrUsernameTaken      = errors.New("username_taken")
+	ErrUsernameNotAllowed = errors.New("username_not_allowed")
+)
+
+// separators allowed inside a username, they don't count for the skeleton
+const usernameSeparators = " _-."
+
+//usernameScripts are the scripts a username can be written in, a name uses
+//only one of them so letters of another script can't pass as lookalikes.
+//The confusables below cover these scripts.
+var usernameScripts = []*unicode.RangeTable{unicode.Latin, unicode.Cyrillic, unicode.Greek}
+
+//usernameConfusables maps lookalike characters to the latin letter they are
+//mistaken for, including the usual digit and symbol replacements
+var usernameConfusables = map[rune]rune{
+	// digits and symbols
+	'0': 'o', '1': 'l', '3': 'e', '4': 'a', '5': 's', '7': 't', '8': 'b',
+	'@': 'a', '$': 's', '|': 'l', '!': 'l',
+	// i and l are hard to tell apart in most fonts
+	'i': 'l', 'ı': 'l', 'ł': 'l',
+	// latin letters with diacritics
+	'à': 'a', 'á': 'a', 'â': 'a', 'ã': 'a', 'ä': 'a', 'å': 'a', 'ā': 'a', 'ă': 'a', 'ą': 'a',
+	'ç': 'c', 'ć': 'c', 'č': 'c', 'ď': 'd', 'đ': 'd',
+	'è': 'e', 'é': 'e', 'ê': 'e', 'ë': 'e', 'ē': 'e', 'ė': 'e', 'ę': 'e', 'ě': 'e',
+	'ì': 'l', 'í': 'l', 'î': 'l', 'ï': 'l', 'ī': 'l', 'į': 'l',
+	'ñ': 'n', 'ń': 'n', 'ň': 'n',
+	'ò': 'o', 'ó': 'o', 'ô': 'o', 'õ': 'o', 'ö': 'o', 'ø': 'o', 'ō': 'o', 'ő': 'o',
+	'ř': 'r', 'ś': 's', 'š': 's', 'ş': 's', 'ß': 's', 'ť': 't', 'ţ': 't',
+	'ù': 'u', 'ú': 'u', 'û': 'u', 'ü': 'u', 'ū': 'u', 'ů': 'u', 'ű': 'u',
+	'ý': 'y', 'ÿ': 'y', 'ź': 'z', 'ż': 'z', 'ž': 'z',
+	// cyrillic
+	'а': 'a', 'в': 'b', 'е': 'e', 'ё': 'e', 'к': 'k', 'м': 'm', 'н': 'h', 'о': 'o',
+	'р': 'p', 'с': 'c', 'т': 't', 'у': 'y', 'х': 'x', 'і': 'l', 'ј': 'j', 'ѕ': 's',
+	// greek
+	'α': 'a', 'β': 'b', 'ε': 'e', 'η': 'n', 'ι': 'l', 'κ': 'k', 'ν': 'v', 'ο': 'o',
+	'ρ': 'p', 'τ': 't', 'υ': 'u', 'χ': 'x',
+}
+
+//UsernameSkeleton reduces a username to the form lookalike names share,
+//two names with the same skeleton count as the same name
+func UsernameSkeleton(username string) string {
+	var skeleton []rune
+	for _, char := range username {
+		// fullwidth forms look like their ascii counterparts
+		if char >= '！' && char <= '～' {
+			char -= 0xfee0
+		}
+		if strings.ContainsRune(usernameSeparators, char) {
+			continue
+		}
+
+		char = unicode.ToLower(char)
+		if confusable, ok := usernameConfusables[char]; ok {
+			char = confusable
+		}
+		skeleton = append(skeleton, char)
+	}
+
+	return strings.Replace(string(skeleton), "rn", "m", -1)
+}
+
+//ValidateUsername checks the length, the characters and the word lists,
+//it returns the username without surrounding spaces
+func ValidateUsername(username string) (string, error) {
+	settings := config.Cfg.UsernameSettings
+	username = strings.TrimSpace(username)
+
+	length := utf8.RuneCountInString(username)
+	if length < settings.MinLength || (settings.MaxLength > 0 && length > settings.MaxLength) {
+		return "", ErrUsernameLength
+	}
+
+	previous := ' '
+	var nameScript *unicode.RangeTable
+	for _, char := range username {
+		if strings.ContainsRune(usernameSeparators, char) {
+			// separators can't follow each other
+			if strings.ContainsRune(usernameSeparators, previous) {
+				return "", ErrUsernameCharset
+			}
+		} else if unicode.IsLetter(char) {
+			script := usernameScript(char)
+			if script == nil || (nameScript != nil && script != nameScript) {
+				return "", ErrUsernameCharset
+			}
+			nameScript = script
+		} else if char < '0' || char > '9' {
+			return "", ErrUsernameCharset
+		}
+		previous = char
+	}
+
+	if usernameHasBlockedWord(username) {
+		return "", ErrUsernameNotAllowed
+	}
+
+	return username, nil
+}
+
+//usernameScript returns the allowed script of a letter
+func usernameScript(char rune) *unicode.RangeTable {
+	for _, script := range usernameScripts {
+		if unicode.Is(script, char) {
+			return script
+		}
+	}
+	return nil
+}
+
+//usernameWords splits a username at separators and where a lower case
+//letter is followed by an upper case one
+func usernameWords(username string) []string {
+	var words []string
+	var word []rune
+	previous := ' '
+	for _, char := range username {
+		if strings.ContainsRune(usernameSeparators, char) || (unicode.IsLower(previous) && unicode.IsUpper(char)) {
+			if len(word) > 0 {
+				words = append(words, string(word))
+			}
+			word = nil
+		}
+		if !strings.ContainsRune(usernameSeparators, char) {
+			word = append(word, char)
+		}
+		previous = char
+	}
+	if len(word) > 0 {
+		words = append(words, string(word))
+	}
+	return words
+}
+
+//usernameHasBlockedWord checks the username against the word lists. Reserved
+//words and profanity have to match the whole name or one of its words, so
+//names like Badminton or Scunthorpe pass. Severe words match anywhere.
+func usernameHasBlockedWord(username string) bool {
+	skeleton := UsernameSkeleton(username)
+
+	for _, word := range config.UsernameWords.Severe {
+		wordSkeleton := UsernameSkeleton(word)
+		if wordSkeleton != "" && strings.Contains(skeleton, wordSkeleton) {
+			return true
+		}
+	}
+
+	nameSkeletons := map[string]bool{skeleton: true}
+	for _, word := range usernameWords(username) {
+		nameSkeletons[UsernameSkeleton(word)] = true
+	}
+
+	words := append([]string{}, config.UsernameWords.Reserved...)
+	for _, languageWords := range config.UsernameWords.Profanity {
+		words = append(words, languageWords...)
+	}
+
+	for _, word := range words {
+		if wordSkeleton := UsernameSkeleton(word); wordSkeleton != "" && nameSkeletons[wordSkeleton] {
+			return true
+		}
+	}
+	return false
+}
+
+//IsUsernameTaken checks if another user has a name with the same skeleton
+func (user *User) IsUsernameTaken(username string) bool {
+	db := GetDatabaseSession()
+
+	var count int
+	db.Model(&User{}).Where("username_skeleton = ? AND id != ?", UsernameSkeleton(username), user.ID).Count(&count)
+	return count > 0
+}
+
+//ChangeUsername validates the new name and sets it, the caller saves the user
+func (user *User) ChangeUsername(username string) error {
+	username, err := ValidateUsername(username)
+	if err != nil {
+		return err
+	}
+
+	if user.IsUsernameTaken(username) {
+		return ErrUsernameTaken
+	}
+
+	user.Username = username
+	user.UsernameSkeleton = UsernameSkeleton(username)
+	return nil
+}
+
+//AdminRename replaces a name which breaks the rules and lets the user know
+func (user *User) AdminRename(username string) error {
+	tmpLog := userLogger.New("func", "AdminRename")
+	previousUsername := user.Username
+
+	if err := user.ChangeUsername(username); err != nil {
+		return err
+	}
+	if err := user.Save(); err != nil {
+		return err
+	}
+	tmpLog.Info(fmt.Sprintf("user '%d' was renamed from '%s' to '%s'", user.ID, previousUsername, user.Username))
+
+	var pushNotification PushNotification
+	go pushNotification.SendUsernameChangedPush(*user, user.Username)
+
+	return nil
+}
+
+//BackfillUsernameSkeletons sets the skeleton of users created before it
+//was stored
+func BackfillUsernameSkeletons() {
+	db := GetDatabaseSession()
+
+	for {
+		var users []User
+		db.Where("username_skeleton = '' OR username_skeleton IS NULL").Limit(500).Find(&users)
+		if len(users) == 0 {
+			return
+		}
+
+		for _, user := range users {
+			skeleton := UsernameSkeleton(user.Username)
+			if skeleton == "" {
+				// keeps the row from being picked up again
+				skeleton = fmt.Sprintf("#%d", user.ID)
+			}
+			if result := db.Exec("UPDATE users SET username_skeleton = ? WHERE id = ?", skeleton, user.ID); result.Error != nil {
+				userLogger.Error(fmt.Sprintf("couldn't backfill username skeletons: %v", result.Error))
+				return
+			}
+		}
+	}
+}
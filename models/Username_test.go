@@ -0,0 +1,72 @@
+package models
+
+import (
+	"testing"
+
+	"timedrop/config"
+)
+
+func TestValidateUsername(t *testing.T) {
+	config.Cfg = &config.Config{UsernameSettings: config.UsernameSettings{MinLength: 3, MaxLength: 20}}
+	config.UsernameWords = config.UsernameWordList{
+		Reserved:  []string{"admin", "staff"},
+		Profanity: map[string][]string{"en": {"cunt"}},
+		Severe:    []string{"fuck"},
+	}
+
+	tests := []struct {
+		username string
+		want     error
+	}{
+		{"Badminton", nil},
+		{"Scunthorpe", nil},
+		{"Staffan", nil},
+		{"Jürgen_1990", nil},
+		{"Дмитрий", nil},
+		{"admin", ErrUsernameNotAllowed},
+		{"4dm1n", ErrUsernameNotAllowed},
+		{"a.d.m.i.n", ErrUsernameNotAllowed},
+		{"Super_Admin", ErrUsernameNotAllowed},
+		{"SuperAdmin", ErrUsernameNotAllowed},
+		{"the staff", ErrUsernameNotAllowed},
+		{"xxfuckxx", ErrUsernameNotAllowed},
+		{"f_u_c_k", ErrUsernameNotAllowed},
+		{"аdmin", ErrUsernameCharset},
+		{"王小明", ErrUsernameCharset},
+		{"bob__bob", ErrUsernameCharset},
+		{"bob!", ErrUsernameCharset},
+		{"ab", ErrUsernameLength},
+	}
+
+	for _, test := range tests {
+		if _, err := ValidateUsername(test.username); err != test.want {
+			t.Errorf("%s: got %v, want %v", test.username, err, test.want)
+		}
+	}
+}
+
+func TestUsernameWords(t *testing.T) {
+	tests := []struct {
+		username string
+		want     []string
+	}{
+		{"SuperAdmin", []string{"Super", "Admin"}},
+		{"super_admin-1", []string{"super", "admin", "1"}},
+		{"ADMIN", []string{"ADMIN"}},
+		{"badminton", []string{"badminton"}},
+	}
+
+	for _, test := range tests {
+		got := usernameWords(test.username)
+		if len(got) != len(test.want) {
+			t.Errorf("%s: got %v, want %v", test.username, got, test.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != test.want[i] {
+				t.Errorf("%s: got %v, want %v", test.username, got, test.want)
+				break
+			}
+		}
+	}
+}
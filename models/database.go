@@ -39,6 +39,29 @@ func Bootstrap() {
 	db.AutoMigrate(&User{})
 	db.AutoMigrate(&Game{})
 	db.AutoMigrate(&Level{})
+	db.AutoMigrate(&CoinTransaction{})
+	db.AutoMigrate(&Purchase{})
+	db.AutoMigrate(&LifeRequest{})
+	db.AutoMigrate(&ReferralCode{})
+	db.AutoMigrate(&ReferralLink{})
+	db.AutoMigrate(&Referral{})
+	db.AutoMigrate(&UserAchievement{})
+	db.AutoMigrate(&Save{})
+	db.AutoMigrate(&UserFieldRevision{})
+	db.AutoMigrate(&DailyAttempt{})
+	db.AutoMigrate(&GroupGame{})
+	db.AutoMigrate(&GameParticipant{})
+	db.AutoMigrate(&GameReplay{})
+	db.AutoMigrate(&Block{})
+	db.AutoMigrate(&Report{})
+	db.AutoMigrate(&UserSearchTerm{})
+	db.AutoMigrate(&ContactLink{})
+	db.AutoMigrate(&ContactImport{})
+	db.AutoMigrate(&FeedItem{})
+	db.AutoMigrate(&UserIdentity{})
+	db.AutoMigrate(&AccountMerge{})
+	db.AutoMigrate(&MergeAttempt{})
+	db.AutoMigrate(&AccountDeletion{})
 
 	var level Level
 	level.Bootstrap()